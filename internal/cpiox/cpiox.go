@@ -0,0 +1,322 @@
+// Package cpiox reads and writes newc format cpio archives, as used by linux
+// initramfs images, from tar headers so the entries of an archive can be
+// produced independently of its format.
+package cpiox
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/tarx"
+)
+
+const (
+	magic      = "070701"
+	headerlen  = 110
+	trailer    = "TRAILER!!!"
+	maxsize    = 1<<32 - 1
+	modeformat = 0170000
+)
+
+// file type bits of the mode field.
+const (
+	modeFifo    = 0010000
+	modeChar    = 0020000
+	modeDir     = 0040000
+	modeBlock   = 0060000
+	modeReg     = 0100000
+	modeSymlink = 0120000
+)
+
+// Mimetype of cpio archives compressed with the codec.
+func Mimetype(codec tarx.Codec) string {
+	switch codec {
+	case tarx.CodecNone:
+		return "application/x-cpio"
+	default:
+		return "application/" + string(codec)
+	}
+}
+
+// WritableCodecs returns the codecs archives can be written with, the
+// codecs are limited to those the linux kernel decompresses with the
+// options they are written with.
+func WritableCodecs() []tarx.Codec {
+	return []tarx.Codec{tarx.CodecNone, tarx.CodecGzip, tarx.CodecZstd}
+}
+
+// Writer writes entries described by tar headers into a newc cpio archive.
+// hardlinks are not supported, every entry receives its own inode.
+type Writer struct {
+	w         io.Writer
+	ino       int64
+	remaining int64
+	pad       int64
+	closed    bool
+}
+
+// NewWriter returns a writer of a cpio archive into dst. closing the writer
+// does not close dst.
+func NewWriter(dst io.Writer) *Writer {
+	return &Writer{w: dst}
+}
+
+// WriteHeader writes the header of an entry, the content of regular files is
+// written afterwards using Write. the target of symlinks is written as their content.
+func (t *Writer) WriteHeader(hdr *tar.Header) (err error) {
+	var (
+		mode  int64
+		nlink int64 = 1
+		size  int64
+		name  = strings.Trim(hdr.Name, "/")
+	)
+
+	if err = t.flush(); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		mode, size = modeReg, hdr.Size
+	case tar.TypeDir:
+		mode, nlink = modeDir, 2
+	case tar.TypeSymlink:
+		mode, size = modeSymlink, int64(len(hdr.Linkname))
+	case tar.TypeChar:
+		mode = modeChar
+	case tar.TypeBlock:
+		mode = modeBlock
+	case tar.TypeFifo:
+		mode = modeFifo
+	default:
+		return errorsx.Errorf("%s: cpio archives do not support %s entries", hdr.Name, tarx.TypeName(hdr.Typeflag))
+	}
+
+	if size > maxsize {
+		return errorsx.Errorf("%s: %d bytes exceeds the maximum cpio entry size of %d bytes", hdr.Name, size, int64(maxsize))
+	}
+
+	if name == "" {
+		name = "."
+	}
+
+	t.ino++
+	if err = t.header(t.ino, mode|(hdr.Mode&07777), int64(hdr.Uid), int64(hdr.Gid), nlink, hdr.ModTime.Unix(), size, hdr.Devmajor, hdr.Devminor, name); err != nil {
+		return err
+	}
+
+	t.remaining, t.pad = size, padding(size)
+	if hdr.Typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(t, hdr.Linkname)
+	}
+
+	return err
+}
+
+// Write the content of the current entry.
+func (t *Writer) Write(b []byte) (n int, err error) {
+	if int64(len(b)) > t.remaining {
+		return 0, errorsx.Errorf("write exceeds the size of the entry by %d bytes", int64(len(b))-t.remaining)
+	}
+
+	n, err = t.w.Write(b)
+	t.remaining -= int64(n)
+	return n, err
+}
+
+// Close writes the trailer of the archive.
+func (t *Writer) Close() (err error) {
+	if t.closed {
+		return nil
+	}
+
+	if err = t.flush(); err != nil {
+		return err
+	}
+
+	if err = t.header(0, 0, 0, 0, 1, 0, 0, 0, 0, trailer); err != nil {
+		return err
+	}
+
+	t.closed = true
+	return nil
+}
+
+// flush pads the content of the current entry.
+func (t *Writer) flush() (err error) {
+	if t.closed {
+		return errorsx.New("write after close")
+	}
+
+	if t.remaining > 0 {
+		return errorsx.Errorf("missed writing %d bytes", t.remaining)
+	}
+
+	_, err = t.w.Write(make([]byte, t.pad))
+	t.pad = 0
+	return err
+}
+
+func (t *Writer) header(ino, mode, uid, gid, nlink, mtime, size, rdevmajor, rdevminor int64, name string) (err error) {
+	encoded := strings.Builder{}
+	encoded.WriteString(magic)
+	for _, v := range []int64{ino, mode, uid, gid, nlink, mtime, size, 0, 0, rdevmajor, rdevminor, int64(len(name) + 1), 0} {
+		fmt.Fprintf(&encoded, "%08X", uint32(v))
+	}
+	encoded.WriteString(name)
+	encoded.WriteByte(0)
+	encoded.Write(make([]byte, padding(int64(encoded.Len()))))
+
+	_, err = io.WriteString(t.w, encoded.String())
+	return err
+}
+
+// WriteFileToArchive writes a file to the archive given the contents and a header.
+func WriteFileToArchive(cw *Writer, hdr *tar.Header, reader io.Reader) (err error) {
+	if err = cw.WriteHeader(hdr); err != nil {
+		return errorsx.Wrap(err, "failed to write header for cpio archive")
+	}
+
+	if _, err = io.Copy(cw, reader); err != nil {
+		return errorsx.Wrap(err, "failed to copy content")
+	}
+
+	return nil
+}
+
+// Reader reads the entries of a newc cpio archive as tar headers.
+type Reader struct {
+	r       *bufio.Reader
+	content io.Reader
+	pad     int64
+}
+
+// NewReader returns a reader of the cpio archive within src.
+func NewReader(src io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(src), content: strings.NewReader("")}
+}
+
+// Next advances to the following entry, io.EOF is returned once the trailer is reached.
+func (t *Reader) Next() (hdr *tar.Header, err error) {
+	var (
+		raw    [headerlen]byte
+		fields [13]int64
+	)
+
+	if _, err = io.Copy(io.Discard, t.content); err != nil {
+		return nil, err
+	}
+
+	if _, err = t.r.Discard(int(t.pad)); err != nil {
+		return nil, errorsx.Wrap(err, "truncated archive")
+	}
+
+	if _, err = io.ReadFull(t.r, raw[:]); err != nil {
+		return nil, errorsx.Wrap(err, "unable to read header")
+	}
+
+	if string(raw[:6]) != magic {
+		return nil, errorsx.Errorf("invalid cpio magic %q, only the newc format is supported", raw[:6])
+	}
+
+	for i := range fields {
+		v, err := strconv.ParseUint(string(raw[6+i*8:14+i*8]), 16, 32)
+		if err != nil {
+			return nil, errorsx.Wrap(err, "invalid header field")
+		}
+		fields[i] = int64(v)
+	}
+
+	mode, uid, gid, mtime, size, rdevmajor, rdevminor, namesize := fields[1], fields[2], fields[3], fields[5], fields[6], fields[9], fields[10], fields[11]
+
+	name := make([]byte, namesize+padding(headerlen+namesize))
+	if _, err = io.ReadFull(t.r, name); err != nil {
+		return nil, errorsx.Wrap(err, "unable to read name")
+	}
+
+	hdr = &tar.Header{
+		Name:     strings.TrimRight(string(name[:namesize]), "\x00"),
+		Mode:     mode & 07777,
+		Uid:      int(uid),
+		Gid:      int(gid),
+		ModTime:  time.Unix(mtime, 0),
+		Devmajor: rdevmajor,
+		Devminor: rdevminor,
+	}
+
+	if hdr.Name == trailer {
+		return nil, io.EOF
+	}
+
+	t.content, t.pad = io.LimitReader(t.r, size), padding(size)
+
+	switch mode & modeformat {
+	case modeReg:
+		hdr.Typeflag, hdr.Size = tar.TypeReg, size
+	case modeDir:
+		hdr.Typeflag = tar.TypeDir
+	case modeSymlink:
+		hdr.Typeflag = tar.TypeSymlink
+		target, err := io.ReadAll(t.content)
+		if err != nil {
+			return nil, errorsx.Wrapf(err, "unable to read symlink %s", hdr.Name)
+		}
+		hdr.Linkname = string(target)
+	case modeChar:
+		hdr.Typeflag = tar.TypeChar
+	case modeBlock:
+		hdr.Typeflag = tar.TypeBlock
+	case modeFifo:
+		hdr.Typeflag = tar.TypeFifo
+	default:
+		return nil, errorsx.Errorf("%s: unsupported file type %o", hdr.Name, mode&modeformat)
+	}
+
+	return hdr, nil
+}
+
+// Read the content of the current entry.
+func (t *Reader) Read(b []byte) (int, error) {
+	return t.content.Read(b)
+}
+
+// prints to stderr information about the archive, the compression codec is detected automatically.
+func Inspect(ctx context.Context, r io.Reader) (err error) {
+	cr, _, err := tarx.Decompress(r)
+	if err != nil {
+		return err
+	}
+	defer cr.Close()
+
+	cpr := NewReader(cr)
+	for {
+		hdr, err := cpr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			log.Println("reg", hdr.Name, hdr.Size)
+		case tar.TypeChar, tar.TypeBlock:
+			log.Println("dev", hdr.Name, hdr.Devmajor, hdr.Devminor)
+		case tar.TypeSymlink:
+			log.Println("symlink", hdr.Name, hdr.Linkname)
+		}
+	}
+}
+
+// padding required to align the offset to 4 bytes.
+func padding(offset int64) int64 {
+	return (4 - offset%4) % 4
+}
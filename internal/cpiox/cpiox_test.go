@@ -0,0 +1,104 @@
+package cpiox_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/egdaemon/egt/internal/cpiox"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCpioxRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	entries := []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{hdr: tar.Header{Name: "dev/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "dev/console", Typeflag: tar.TypeChar, Mode: 0600, Devmajor: 5, Devminor: 1}},
+		{hdr: tar.Header{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0660, Gid: 6, Devmajor: 8}},
+		{hdr: tar.Header{Name: "run/initctl", Typeflag: tar.TypeFifo, Mode: 0600}},
+		{hdr: tar.Header{Name: "init", Typeflag: tar.TypeReg, Mode: 0755, Uid: 1000, Gid: 1000}, contents: "#!/bin/sh\nexec /sbin/init\n"},
+		{hdr: tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0600}},
+		{hdr: tar.Header{Name: "sbin/init", Typeflag: tar.TypeSymlink, Mode: 0777, Linkname: "../lib/systemd/systemd"}},
+	}
+
+	for _, codec := range WritableCodecs() {
+		t.Run(string(codec), func(t *testing.T) {
+			var buf bytes.Buffer
+			cw, err := codec.NewWriter(&buf, tarx.LevelDefault)
+			require.NoError(t, err)
+			w := NewWriter(cw)
+			for _, e := range entries {
+				e.hdr.ModTime = ts
+				e.hdr.Size = int64(len(e.contents))
+				require.NoError(t, WriteFileToArchive(w, &e.hdr, strings.NewReader(e.contents)))
+			}
+			require.NoError(t, w.Close())
+			require.NoError(t, cw.Close())
+
+			cr, detected, err := tarx.Decompress(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			defer cr.Close()
+			require.Equal(t, codec, detected)
+
+			r := NewReader(cr)
+			for _, e := range entries {
+				hdr, err := r.Next()
+				require.NoError(t, err)
+				contents, err := io.ReadAll(r)
+				require.NoError(t, err)
+
+				require.Equal(t, strings.TrimSuffix(e.hdr.Name, "/"), hdr.Name)
+				require.Equal(t, e.hdr.Typeflag, hdr.Typeflag)
+				require.Equal(t, e.hdr.Mode, hdr.Mode)
+				require.Equal(t, e.hdr.Uid, hdr.Uid)
+				require.Equal(t, e.hdr.Gid, hdr.Gid)
+				require.Equal(t, e.hdr.Devmajor, hdr.Devmajor)
+				require.Equal(t, e.hdr.Devminor, hdr.Devminor)
+				require.Equal(t, e.hdr.Linkname, hdr.Linkname)
+				require.True(t, ts.Equal(hdr.ModTime))
+				if e.hdr.Typeflag == tar.TypeReg {
+					require.Equal(t, e.contents, string(contents))
+				}
+			}
+
+			_, err = r.Next()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestCpioxWriterAlignment(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, WriteFileToArchive(w, &tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}, strings.NewReader("abc")))
+	require.NoError(t, w.Close())
+	require.Zero(t, buf.Len()%4)
+	require.True(t, strings.HasPrefix(buf.String(), "070701"))
+	require.Contains(t, buf.String(), "TRAILER!!!\x00")
+}
+
+func TestCpioxWriterErrors(t *testing.T) {
+	w := NewWriter(io.Discard)
+	require.ErrorContains(t, w.WriteHeader(&tar.Header{Name: "alias", Typeflag: tar.TypeLink, Linkname: "a"}), "cpio archives do not support hardlink entries")
+
+	require.NoError(t, w.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Size: 3}))
+	_, err := w.Write([]byte("abcd"))
+	require.Error(t, err)
+	require.ErrorContains(t, w.Close(), "missed writing 3 bytes")
+}
+
+func TestCpioxInspect(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, WriteFileToArchive(w, &tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 3}, strings.NewReader("abc")))
+	require.NoError(t, w.Close())
+	require.NoError(t, Inspect(context.Background(), bytes.NewReader(buf.Bytes())))
+}
@@ -0,0 +1,359 @@
+// Package debx writes debian binary packages from tar headers so the entries
+// of the package can be produced independently of its format. the control
+// archive, including the md5sums of every file, is generated from the entries.
+package debx
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	pathpkg "path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/tarx"
+)
+
+// Mimetype of debian binary packages.
+const Mimetype = "application/vnd.debian.binary-package"
+
+// Version of the package format.
+const Version = "2.0\n"
+
+// Scripts are the names of the maintainer scripts.
+func Scripts() []string {
+	return []string{"preinst", "postinst", "prerm", "postrm"}
+}
+
+// WritableCodecs returns the codecs the control and data archives can be written with.
+func WritableCodecs() []tarx.Codec {
+	return []tarx.Codec{tarx.CodecNone, tarx.CodecGzip, tarx.CodecXz, tarx.CodecZstd}
+}
+
+// extension of archives compressed with the codec.
+func extension(codec tarx.Codec) string {
+	switch codec {
+	case tarx.CodecGzip:
+		return ".gz"
+	case tarx.CodecXz:
+		return ".xz"
+	case tarx.CodecZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// Control fields of a package.
+type Control struct {
+	Package      string
+	Version      string
+	Architecture string
+	Maintainer   string
+	// InstalledSize in KiB, computed from the entries of the package when zero.
+	InstalledSize int64
+	Section       string
+	Priority      string
+	Homepage      string
+	Essential     bool
+	PreDepends    []string
+	Depends       []string
+	Recommends    []string
+	Suggests      []string
+	Breaks        []string
+	Conflicts     []string
+	Replaces      []string
+	Provides      []string
+	// Fields not otherwise represented, written ordered by name.
+	Fields map[string]string
+	// Description of the package, the first line is the synopsis.
+	Description string
+}
+
+// Marshal the control fields into the control file format.
+func (t Control) Marshal() []byte {
+	var (
+		buf = bytes.Buffer{}
+	)
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&buf, "%s: %s\n", name, value)
+		}
+	}
+
+	field("Package", t.Package)
+	field("Version", t.Version)
+	field("Architecture", t.Architecture)
+	field("Maintainer", t.Maintainer)
+	if t.InstalledSize > 0 {
+		field("Installed-Size", fmt.Sprint(t.InstalledSize))
+	}
+	if t.Essential {
+		field("Essential", "yes")
+	}
+	field("Pre-Depends", strings.Join(t.PreDepends, ", "))
+	field("Depends", strings.Join(t.Depends, ", "))
+	field("Recommends", strings.Join(t.Recommends, ", "))
+	field("Suggests", strings.Join(t.Suggests, ", "))
+	field("Breaks", strings.Join(t.Breaks, ", "))
+	field("Conflicts", strings.Join(t.Conflicts, ", "))
+	field("Replaces", strings.Join(t.Replaces, ", "))
+	field("Provides", strings.Join(t.Provides, ", "))
+	field("Section", t.Section)
+	field("Priority", t.Priority)
+	field("Homepage", t.Homepage)
+	for _, name := range slices.Sorted(maps.Keys(t.Fields)) {
+		field(name, t.Fields[name])
+	}
+
+	// the extended description is indented, blank lines are represented by a dot.
+	lines := strings.Split(strings.TrimRight(t.Description, "\n"), "\n")
+	for i, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			line = "."
+		}
+		lines[i+1] = " " + line
+	}
+	field("Description", strings.Join(lines, "\n"))
+
+	return buf.Bytes()
+}
+
+// Package is the control information of a package beyond its entries.
+type Package struct {
+	Control Control
+	// Scripts are the maintainer scripts keyed by name, e.g. postinst.
+	Scripts map[string]string
+	// Conffiles are the absolute paths of the configuration files within the package.
+	Conffiles []string
+}
+
+// Writer writes entries described by tar headers into the data archive of a
+// package. the package is written once the writer is closed, the data archive
+// is staged in a temporary file until then.
+type Writer struct {
+	dst       io.Writer
+	pkg       Package
+	codec     tarx.Codec
+	level     int
+	staged    *os.File
+	cw        io.WriteCloser
+	tw        *tar.Writer
+	md5sums   bytes.Buffer
+	files     map[string]bool
+	installed int64
+	mtime     time.Time
+	started   bool
+	closed    bool
+}
+
+// NewWriter returns a writer of the package into dst compressing the control
+// and data archives with the codec. closing the writer does not close dst.
+func NewWriter(dst io.Writer, pkg Package, codec tarx.Codec, level int) (_ *Writer, err error) {
+	if !slices.Contains(WritableCodecs(), codec) {
+		return nil, errorsx.Errorf("%s compression is not supported by debian packages", codec)
+	}
+
+	staged, err := os.CreateTemp("", ".egt.deb.*")
+	if err != nil {
+		return nil, errorsx.Wrap(err, "failed to stage data archive")
+	}
+
+	cw, err := codec.NewWriter(staged, level)
+	if err != nil {
+		return nil, errorsx.Compact(err, staged.Close(), os.Remove(staged.Name()))
+	}
+
+	return &Writer{dst: dst, pkg: pkg, codec: codec, level: level, staged: staged, cw: cw, tw: tar.NewWriter(cw), files: map[string]bool{}, mtime: time.Unix(0, 0)}, nil
+}
+
+// WriteHeader writes an entry without content.
+func (t *Writer) WriteHeader(hdr *tar.Header) error {
+	return t.WriteFile(hdr, strings.NewReader(""))
+}
+
+// WriteFile writes an entry along with its content. entries are written
+// relative to the root directory, e.g. ./usr/bin/tool.
+func (t *Writer) WriteFile(hdr *tar.Header, src io.Reader) (err error) {
+	var (
+		digest = md5.New()
+		name   = strings.Trim(pathpkg.Clean("/"+hdr.Name), "/")
+	)
+
+	if t.closed {
+		return errorsx.New("write after close")
+	}
+
+	if !t.started {
+		t.started = true
+		if err = t.tw.WriteHeader(tarx.Normalize(tarx.NewDirHeader(".", hdr.ModTime, 0755))); err != nil {
+			return err
+		}
+	}
+
+	if hdr.ModTime.After(t.mtime) {
+		t.mtime = hdr.ModTime
+	}
+
+	entry := *hdr
+	entry.Name = "./" + name
+	if entry.Typeflag == tar.TypeDir {
+		entry.Name += "/"
+	}
+
+	if entry.Typeflag == tar.TypeLink {
+		entry.Linkname = "./" + strings.Trim(pathpkg.Clean("/"+hdr.Linkname), "/")
+	}
+
+	if entry.Typeflag != tar.TypeReg {
+		t.installed++
+		if err = t.tw.WriteHeader(&entry); err != nil {
+			return errorsx.Wrapf(err, "failed to write header for %s", hdr.Name)
+		}
+
+		return nil
+	}
+
+	t.installed += (entry.Size + 1023) / 1024
+	if err = tarx.WriteFileToArchive(t.tw, &entry, io.TeeReader(src, digest)); err != nil {
+		return err
+	}
+
+	t.files["/"+name] = true
+	fmt.Fprintf(&t.md5sums, "%s  %s\n", hex.EncodeToString(digest.Sum(nil)), name)
+	return nil
+}
+
+// Close writes the package.
+func (t *Writer) Close() (err error) {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	defer os.Remove(t.staged.Name())
+	defer t.staged.Close()
+
+	if err = errorsx.Compact(t.tw.Close(), t.cw.Close()); err != nil {
+		return errorsx.Wrap(err, "failed to finalize data archive")
+	}
+
+	for _, conffile := range t.pkg.Conffiles {
+		if !t.files[conffile] {
+			return errorsx.Errorf("conffile %s is not a regular file within the package", conffile)
+		}
+	}
+
+	control, err := t.control()
+	if err != nil {
+		return errorsx.Wrap(err, "failed to generate control archive")
+	}
+
+	size, err := t.staged.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if err = iox.Rewind(t.staged); err != nil {
+		return err
+	}
+
+	if _, err = io.WriteString(t.dst, "!<arch>\n"); err != nil {
+		return err
+	}
+
+	if err = t.member("debian-binary", int64(len(Version)), strings.NewReader(Version)); err != nil {
+		return err
+	}
+
+	if err = t.member("control.tar"+extension(t.codec), int64(len(control)), bytes.NewReader(control)); err != nil {
+		return err
+	}
+
+	return t.member("data.tar"+extension(t.codec), size, t.staged)
+}
+
+// control archive of the package.
+func (t *Writer) control() (_ []byte, err error) {
+	var (
+		buf      = bytes.Buffer{}
+		control  = t.pkg.Control
+		root     = tarx.HeaderOptionOwnerNames("root", "root")
+		conffile = strings.Join(t.pkg.Conffiles, "\n")
+	)
+
+	if control.InstalledSize == 0 {
+		control.InstalledSize = t.installed
+	}
+
+	if conffile != "" {
+		conffile += "\n"
+	}
+
+	cw, err := t.codec.NewWriter(&buf, t.level)
+	if err != nil {
+		return nil, err
+	}
+
+	tw := tar.NewWriter(cw)
+	if err = tw.WriteHeader(tarx.Normalize(tarx.NewDirHeader(".", t.mtime, 0755, root))); err != nil {
+		return nil, err
+	}
+
+	write := func(name string, mode int64, content []byte) error {
+		if len(content) == 0 {
+			return nil
+		}
+
+		return tarx.WriteFileToArchive(tw, tarx.Normalize(tarx.NewHeader("./"+name, t.mtime, int64(len(content)), mode, root)), bytes.NewReader(content))
+	}
+
+	if err = write("control", 0644, control.Marshal()); err != nil {
+		return nil, err
+	}
+
+	if err = write("md5sums", 0644, t.md5sums.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err = write("conffiles", 0644, []byte(conffile)); err != nil {
+		return nil, err
+	}
+
+	for _, name := range Scripts() {
+		if err = write(name, 0755, []byte(t.pkg.Scripts[name])); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = errorsx.Compact(tw.Close(), cw.Close()); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// member writes a file into the ar container, content is padded to an even offset.
+func (t *Writer) member(name string, size int64, src io.Reader) (err error) {
+	if _, err = fmt.Fprintf(t.dst, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, t.mtime.Unix(), 0, 0, "100644", size); err != nil {
+		return err
+	}
+
+	if _, err = io.CopyN(t.dst, src, size); err != nil {
+		return errorsx.Wrapf(err, "failed to write %s", name)
+	}
+
+	if size%2 == 1 {
+		_, err = io.WriteString(t.dst, "\n")
+	}
+
+	return err
+}
@@ -0,0 +1,148 @@
+package debx_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/egdaemon/egt/internal/debx"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/stretchr/testify/require"
+)
+
+type member struct {
+	name     string
+	mtime    int64
+	contents []byte
+}
+
+// members of the ar container.
+func members(t *testing.T, encoded []byte) (found []member) {
+	require.True(t, bytes.HasPrefix(encoded, []byte("!<arch>\n")))
+	encoded = encoded[8:]
+	for len(encoded) > 0 {
+		hdr := string(encoded[:60])
+		require.Equal(t, "`\n", hdr[58:])
+		mtime, err := strconv.ParseInt(strings.TrimSpace(hdr[16:28]), 10, 64)
+		require.NoError(t, err)
+		size, err := strconv.ParseInt(strings.TrimSpace(hdr[48:58]), 10, 64)
+		require.NoError(t, err)
+		found = append(found, member{name: strings.TrimSpace(hdr[:16]), mtime: mtime, contents: encoded[60 : 60+size]})
+		encoded = encoded[60+size+size%2:]
+	}
+
+	return found
+}
+
+// entries of the compressed tar archive keyed by name.
+func entries(t *testing.T, encoded []byte) (names []string, contents map[string]string) {
+	cr, _, err := tarx.Decompress(bytes.NewReader(encoded))
+	require.NoError(t, err)
+	defer cr.Close()
+
+	contents = map[string]string{}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = string(b) + hdr.Linkname
+	}
+}
+
+func TestDebxControlMarshal(t *testing.T) {
+	control := Control{
+		Package:       "agent",
+		Version:       "1:1.2.3-1",
+		Architecture:  "amd64",
+		Maintainer:    "Jane Doe <jane@example.com>",
+		InstalledSize: 12,
+		Depends:       []string{"libc6 (>= 2.34)", "adduser"},
+		Section:       "admin",
+		Fields:        map[string]string{"X-Origin": "egd", "Built-Using": "golang (= 1.23)"},
+		Description:   "monitoring agent\nreports the health of the host.\n\nruns as a service.\n",
+	}
+
+	require.Equal(t, strings.Join([]string{
+		"Package: agent",
+		"Version: 1:1.2.3-1",
+		"Architecture: amd64",
+		"Maintainer: Jane Doe <jane@example.com>",
+		"Installed-Size: 12",
+		"Depends: libc6 (>= 2.34), adduser",
+		"Section: admin",
+		"Built-Using: golang (= 1.23)",
+		"X-Origin: egd",
+		"Description: monitoring agent",
+		" reports the health of the host.",
+		" .",
+		" runs as a service.",
+		"",
+	}, "\n"), string(control.Marshal()))
+}
+
+func TestDebxWriter(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	binary := strings.Repeat("x", 2000)
+	pkg := Package{
+		Control:   Control{Package: "agent", Version: "1.0", Architecture: "all", Maintainer: "ops <ops@example.com>", Description: "agent"},
+		Scripts:   map[string]string{"postinst": "#!/bin/sh\nexit 0\n"},
+		Conffiles: []string{"/etc/agent.conf"},
+	}
+
+	for _, codec := range WritableCodecs() {
+		t.Run(string(codec), func(t *testing.T) {
+			encoded := bytes.Buffer{}
+			w, err := NewWriter(&encoded, pkg, codec, tarx.LevelDefault)
+			require.NoError(t, err)
+			require.NoError(t, w.WriteHeader(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: ts}))
+			require.NoError(t, w.WriteFile(&tar.Header{Name: "etc/agent.conf", Typeflag: tar.TypeReg, Mode: 0644, Size: 4, ModTime: ts}, strings.NewReader("a=1\n")))
+			require.NoError(t, w.WriteHeader(&tar.Header{Name: "usr/bin", Typeflag: tar.TypeDir, Mode: 0755, ModTime: ts}))
+			require.NoError(t, w.WriteFile(&tar.Header{Name: "usr/bin/agent", Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(binary)), ModTime: ts}, strings.NewReader(binary)))
+			require.NoError(t, w.WriteHeader(&tar.Header{Name: "usr/bin/agentd", Typeflag: tar.TypeLink, Linkname: "usr/bin/agent", ModTime: ts}))
+			require.NoError(t, w.Close())
+
+			found := members(t, encoded.Bytes())
+			require.Len(t, found, 3)
+			ext := map[tarx.Codec]string{tarx.CodecNone: "", tarx.CodecGzip: ".gz", tarx.CodecXz: ".xz", tarx.CodecZstd: ".zst"}[codec]
+			require.Equal(t, []string{"debian-binary", "control.tar" + ext, "data.tar" + ext}, []string{found[0].name, found[1].name, found[2].name})
+			require.Equal(t, "2.0\n", string(found[0].contents))
+			for _, m := range found {
+				require.Equal(t, ts.Unix(), m.mtime)
+			}
+
+			names, data := entries(t, found[2].contents)
+			require.Equal(t, []string{"./", "./etc/", "./etc/agent.conf", "./usr/bin/", "./usr/bin/agent", "./usr/bin/agentd"}, names)
+			require.Equal(t, "./usr/bin/agent", data["./usr/bin/agentd"])
+
+			names, control := entries(t, found[1].contents)
+			require.Equal(t, []string{"./", "./control", "./md5sums", "./conffiles", "./postinst"}, names)
+			require.Contains(t, control["./control"], "Installed-Size: 6\n")
+			require.Equal(t, "/etc/agent.conf\n", control["./conffiles"])
+			require.Equal(t, pkg.Scripts["postinst"], control["./postinst"])
+
+			sum := func(s string) string {
+				d := md5.Sum([]byte(s))
+				return hex.EncodeToString(d[:])
+			}
+			require.Equal(t, sum("a=1\n")+"  etc/agent.conf\n"+sum(binary)+"  usr/bin/agent\n", control["./md5sums"])
+		})
+	}
+}
+
+func TestDebxWriterMissingConffile(t *testing.T) {
+	w, err := NewWriter(io.Discard, Package{Conffiles: []string{"/etc/missing.conf"}}, tarx.CodecGzip, tarx.LevelDefault)
+	require.NoError(t, err)
+	require.ErrorContains(t, w.Close(), "conffile /etc/missing.conf is not a regular file within the package")
+}
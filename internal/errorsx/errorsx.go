@@ -1,6 +1,7 @@
 package errorsx
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -158,3 +159,38 @@ func MaybeLog(err error) {
 		log.Println(cause)
 	}
 }
+
+// IsUserFriendly reports if any error in the chain is meant to be displayed to
+// users, setting target to that error when it is.
+func IsUserFriendly(err error, target *error) bool {
+	var friendly interface {
+		error
+		UserFriendly()
+	}
+
+	if !errors.As(err, &friendly) {
+		return false
+	}
+
+	if target != nil {
+		*target = friendly
+	}
+
+	return true
+}
+
+// IsUnauthorized reports if any error in the chain is an authorization failure.
+func IsUnauthorized(err error) bool {
+	var target Unauthorized
+	return errors.As(err, &target)
+}
+
+// IsTimedout reports if any error in the chain is a timeout along with its duration.
+func IsTimedout(err error) (time.Duration, bool) {
+	var target Timeout
+	if errors.As(err, &target) {
+		return target.Timedout(), true
+	}
+
+	return 0, false
+}
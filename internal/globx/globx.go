@@ -0,0 +1,139 @@
+// Package globx implements slash separated glob matching with support for
+// `**` segments and gitignore style ignore files.
+package globx
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// Match reports whether the slash separated name matches the pattern.
+// Segments support the syntax of path.Match, additionally a `**` segment
+// matches zero or more segments. malformed patterns never match.
+func Match(pattern, name string) bool {
+	return match(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// Any reports whether the name matches any of the patterns.
+func Any(name string, patterns ...string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if match(rest, name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// Rule is a single line of an ignore file.
+type Rule struct {
+	base     string
+	pattern  string
+	negate   bool
+	dironly  bool
+	anchored bool
+}
+
+// ParseIgnore reads gitignore style rules from the reader. base is the
+// slash separated directory the ignore file was found in, rules only
+// apply to names beneath it.
+func ParseIgnore(base string, r io.Reader) (rules []Rule, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := Rule{base: strings.Trim(base, "/")}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+
+		// escaped leading characters are taken literally.
+		line = strings.TrimPrefix(line, "\\")
+
+		if strings.HasSuffix(line, "/") {
+			rule.dironly = true
+			line = strings.TrimRight(line, "/")
+		}
+
+		if strings.HasPrefix(line, "/") {
+			rule.anchored = true
+			line = strings.TrimLeft(line, "/")
+		}
+
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+		}
+
+		if line == "" {
+			continue
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// Ignored reports whether the slash separated name is ignored by the rules,
+// the last matching rule wins.
+func Ignored(name string, dir bool, rules ...Rule) (ignored bool) {
+	for _, r := range rules {
+		if r.dironly && !dir {
+			continue
+		}
+
+		rel := name
+		if r.base != "" {
+			if !strings.HasPrefix(name, r.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(name, r.base+"/")
+		}
+
+		pattern := r.pattern
+		if !r.anchored {
+			pattern = "**/" + pattern
+		}
+
+		if Match(pattern, rel) {
+			ignored = !r.negate
+		}
+	}
+
+	return ignored
+}
@@ -0,0 +1,46 @@
+package globx_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/egdaemon/egt/internal/globx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobxMatch(t *testing.T) {
+	assert.True(t, Match("*.yaml", "config.yaml"))
+	assert.False(t, Match("*.yaml", "etc/config.yaml"))
+	assert.True(t, Match("**/*.yaml", "config.yaml"))
+	assert.True(t, Match("**/*.yaml", "etc/app/config.yaml"))
+	assert.True(t, Match("etc/**", "etc/app/config.yaml"))
+	assert.False(t, Match("etc/**/*.txt", "etc/app/config.yaml"))
+	assert.False(t, Match("[", "["))
+}
+
+func TestGlobxIgnored(t *testing.T) {
+	rules, err := ParseIgnore("", strings.NewReader(`
+# comment
+*.tmp
+!keep.tmp
+/build
+logs/
+docs/*.md
+`))
+	assert.NoError(t, err)
+
+	assert.True(t, Ignored("a.tmp", false, rules...))
+	assert.True(t, Ignored("nested/a.tmp", false, rules...))
+	assert.False(t, Ignored("nested/keep.tmp", false, rules...))
+	assert.True(t, Ignored("build", true, rules...))
+	assert.False(t, Ignored("src/build", true, rules...))
+	assert.True(t, Ignored("src/logs", true, rules...))
+	assert.False(t, Ignored("src/logs", false, rules...))
+	assert.True(t, Ignored("docs/readme.md", false, rules...))
+	assert.False(t, Ignored("docs/nested/readme.md", false, rules...))
+
+	nested, err := ParseIgnore("sub", strings.NewReader("*.txt\n"))
+	assert.NoError(t, err)
+	assert.True(t, Ignored("sub/a.txt", false, nested...))
+	assert.False(t, Ignored("a.txt", false, nested...))
+}
@@ -1,7 +1,10 @@
 package iox
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"hash"
 	"io"
 	"os"
 )
@@ -97,3 +100,33 @@ func String(in io.Reader) (s string, err error) {
 
 	return string(raw), nil
 }
+
+// Sha256 computes the hex encoded sha256 of the file at the given path.
+func Sha256(path string) (_ string, err error) {
+	return Digest(path, sha256.New())
+}
+
+// Digest computes the hex encoded digest of the file at path using the hash.
+func Digest(path string, digest hash.Hash) (_ string, err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if _, err = io.Copy(digest, src); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Counter is an io.Writer that counts the bytes written to it.
+type Counter struct {
+	N int64
+}
+
+func (t *Counter) Write(b []byte) (int, error) {
+	t.N += int64(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,79 @@
+package ocix
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+)
+
+// DockerManifest describes an image within a docker archive, as read by `docker load`.
+type DockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ParseRepoTag splits a reference of the form repository:tag, e.g.
+// example.com:5000/app:1.0, the tag is required.
+func ParseRepoTag(s string) (repository, tag string, err error) {
+	idx := strings.LastIndex(s, ":")
+	if idx <= 0 || idx == len(s)-1 || strings.Contains(s[idx:], "/") || strings.Contains(s, "@") {
+		return "", "", errorsx.Errorf("invalid repository tag %q, expected repository:tag", s)
+	}
+
+	return s[:idx], s[idx+1:], nil
+}
+
+// WriteDockerArchive writes a docker archive of the image, the blobs must
+// contain the config and every layer. the blobs are stored at their path
+// within an image layout as written by docker 25 and later.
+func WriteDockerArchive(w Writer, config Descriptor, layers []Descriptor, tags []string, blobs ...Blob) (err error) {
+	var (
+		repositories = map[string]map[string]string{}
+		manifest     = DockerManifest{Config: BlobPath(config), RepoTags: tags, Layers: make([]string, 0, len(layers))}
+	)
+
+	if manifest.RepoTags == nil {
+		manifest.RepoTags = []string{}
+	}
+
+	for _, l := range layers {
+		manifest.Layers = append(manifest.Layers, BlobPath(l))
+	}
+
+	// repositories associates each tag with the top most layer.
+	for _, t := range tags {
+		repository, tag, err := ParseRepoTag(t)
+		if err != nil {
+			return err
+		}
+
+		if repositories[repository] == nil {
+			repositories[repository] = map[string]string{}
+		}
+
+		if len(layers) > 0 {
+			_, repositories[repository][tag], _ = strings.Cut(layers[len(layers)-1].Digest, ":")
+		}
+	}
+
+	if err = WriteBlobs(w, blobs...); err != nil {
+		return err
+	}
+
+	if err = writejson(w, "manifest.json", []DockerManifest{manifest}); err != nil {
+		return err
+	}
+
+	return writejson(w, "repositories", repositories)
+}
+
+func writejson(w Writer, name string, v any) error {
+	desc, encoded, err := Marshal(name, v)
+	if err != nil {
+		return err
+	}
+
+	return w.WriteFile(name, desc.Size, bytes.NewReader(encoded))
+}
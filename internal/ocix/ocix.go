@@ -0,0 +1,322 @@
+// Package ocix assembles OCI images from layer blobs and writes them as OCI
+// image layouts, either a directory or a tarball, as accepted by podman and
+// skopeo, or as docker archives as accepted by `docker load`.
+package ocix
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/tarx"
+)
+
+// media types of the documents and blobs within an image.
+const (
+	MediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// AnnotationRefName names a manifest within the index of a layout, e.g. the tag of the image.
+const AnnotationRefName = "org.opencontainers.image.ref.name"
+
+// LayoutVersion of the image layouts written.
+const LayoutVersion = "1.0.0"
+
+// Descriptor references a blob by its digest.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform an image runs on.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses a platform of the form os/architecture[/variant], e.g. linux/arm64/v8.
+func ParsePlatform(s string) (p Platform, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return p, errorsx.Errorf("invalid platform %q, expected os/architecture[/variant]", s)
+	}
+
+	p.OS, p.Architecture = parts[0], parts[1]
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+// Index of the manifests within a layout.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest of an image referencing its config and layers.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Image configuration, the runtime defaults along with the diff ids of the layers.
+type Image struct {
+	Created      *time.Time `json:"created,omitempty"`
+	Architecture string     `json:"architecture"`
+	OS           string     `json:"os"`
+	Variant      string     `json:"variant,omitempty"`
+	Config       Config     `json:"config"`
+	RootFS       RootFS     `json:"rootfs"`
+}
+
+// Config of containers created from an image.
+type Config struct {
+	User       string            `json:"User,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+// RootFS lists the uncompressed digests of the layers in order.
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// LayerMediaType of layers compressed with the codec, only uncompressed, gzip
+// and zstd layers are defined by the specification.
+func LayerMediaType(codec tarx.Codec) (string, error) {
+	switch codec {
+	case tarx.CodecNone:
+		return MediaTypeLayer, nil
+	case tarx.CodecGzip, tarx.CodecZstd:
+		return MediaTypeLayer + "+" + string(codec), nil
+	default:
+		return "", errorsx.Errorf("%s compressed layers are not supported by OCI images", codec)
+	}
+}
+
+// Layer describes the layer blob within src returning its descriptor along
+// with the digest of the uncompressed tar archive, the diff id.
+func Layer(src io.Reader) (desc Descriptor, diffid string, err error) {
+	var (
+		digest       = sha256.New()
+		uncompressed = sha256.New()
+		counter      = &iox.Counter{}
+		blob         = io.TeeReader(src, io.MultiWriter(digest, counter))
+	)
+
+	cr, codec, err := tarx.Decompress(blob)
+	if err != nil {
+		return desc, "", err
+	}
+	defer cr.Close()
+
+	if desc.MediaType, err = LayerMediaType(codec); err != nil {
+		return desc, "", err
+	}
+
+	// the entries are read to reject blobs which are not tar archives, the
+	// remainder is drained since the diff id covers the trailing padding.
+	raw := io.TeeReader(cr, uncompressed)
+	tr := tar.NewReader(raw)
+	for {
+		if _, err = tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return desc, "", errorsx.Wrap(err, "layer is not a tar archive")
+		}
+	}
+
+	if _, err = io.Copy(io.Discard, raw); err != nil {
+		return desc, "", errorsx.Wrap(err, "failed to read layer")
+	}
+
+	// drain the compressed stream, e.g. trailing padding after the compressed frames.
+	if _, err = io.Copy(io.Discard, blob); err != nil {
+		return desc, "", errorsx.Wrap(err, "failed to read layer")
+	}
+
+	desc.Digest = "sha256:" + hex.EncodeToString(digest.Sum(nil))
+	desc.Size = counter.N
+	return desc, "sha256:" + hex.EncodeToString(uncompressed.Sum(nil)), nil
+}
+
+// Marshal the document returning its encoding along with its descriptor.
+func Marshal(mediatype string, v any) (desc Descriptor, encoded []byte, err error) {
+	if encoded, err = json.Marshal(v); err != nil {
+		return desc, nil, errorsx.Wrapf(err, "failed to encode %s", mediatype)
+	}
+
+	digest := sha256.Sum256(encoded)
+	return Descriptor{MediaType: mediatype, Digest: "sha256:" + hex.EncodeToString(digest[:]), Size: int64(len(encoded))}, encoded, nil
+}
+
+// BlobPath of the blob within a layout.
+func BlobPath(desc Descriptor) string {
+	algorithm, encoded, _ := strings.Cut(desc.Digest, ":")
+	return "blobs/" + algorithm + "/" + encoded
+}
+
+// Blob to write into a layout along with the means to read its content.
+type Blob struct {
+	Descriptor
+	Open func() (io.ReadCloser, error)
+}
+
+// BytesBlob is a blob held in memory, e.g. a manifest.
+func BytesBlob(desc Descriptor, encoded []byte) Blob {
+	return Blob{
+		Descriptor: desc,
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(encoded)), nil
+		},
+	}
+}
+
+// Writer of the files within a layout.
+type Writer interface {
+	// WriteFile writes the file at the slash separated name creating its parent directories.
+	WriteFile(name string, size int64, src io.Reader) error
+	Close() error
+}
+
+// WriteLayout writes an image layout with the manifests, the blobs must
+// contain every document and layer referenced by the manifests. blobs
+// sharing a digest are written once.
+func WriteLayout(w Writer, manifests []Descriptor, blobs ...Blob) (index Descriptor, err error) {
+	layout, encoded, err := Marshal("", map[string]string{"imageLayoutVersion": LayoutVersion})
+	if err != nil {
+		return index, err
+	}
+
+	if err = w.WriteFile("oci-layout", layout.Size, bytes.NewReader(encoded)); err != nil {
+		return index, err
+	}
+
+	if err = WriteBlobs(w, blobs...); err != nil {
+		return index, err
+	}
+
+	index, encoded, err = Marshal(MediaTypeIndex, Index{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: manifests})
+	if err != nil {
+		return index, err
+	}
+
+	return index, w.WriteFile("index.json", index.Size, bytes.NewReader(encoded))
+}
+
+// WriteBlobs writes the blobs at their path within a layout.
+func WriteBlobs(w Writer, blobs ...Blob) (err error) {
+	written := make(map[string]bool, len(blobs))
+	for _, b := range blobs {
+		if written[b.Digest] {
+			continue
+		}
+		written[b.Digest] = true
+
+		src, err := b.Open()
+		if err != nil {
+			return errorsx.Wrapf(err, "unable to read blob %s", b.Digest)
+		}
+
+		err = w.WriteFile(BlobPath(b.Descriptor), b.Size, src)
+		if err = errorsx.Compact(err, src.Close()); err != nil {
+			return errorsx.Wrapf(err, "failed to write blob %s", b.Digest)
+		}
+	}
+
+	return nil
+}
+
+// NewDirWriter returns a writer of files within the root directory.
+func NewDirWriter(root string, fileperm, dirperm os.FileMode) Writer {
+	return &dirwriter{root: root, fileperm: fileperm, dirperm: dirperm}
+}
+
+type dirwriter struct {
+	root     string
+	fileperm os.FileMode
+	dirperm  os.FileMode
+}
+
+func (t *dirwriter) WriteFile(name string, size int64, src io.Reader) (err error) {
+	p := filepath.Join(t.root, filepath.FromSlash(name))
+	if err = os.MkdirAll(filepath.Dir(p), t.dirperm); err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, t.fileperm)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(dst, src)
+	if err = errorsx.Compact(err, dst.Close()); err != nil {
+		return err
+	}
+
+	if n != size {
+		return errorsx.Errorf("%s: wrote %d bytes, expected %d", name, n, size)
+	}
+
+	return nil
+}
+
+func (t *dirwriter) Close() error {
+	return nil
+}
+
+// NewTarWriter returns a writer of files into a tar archive with the
+// modification time, closing the writer does not close dst.
+func NewTarWriter(dst io.Writer, mtime time.Time) Writer {
+	return &tarwriter{tw: tar.NewWriter(dst), mtime: mtime, dirs: map[string]bool{}}
+}
+
+type tarwriter struct {
+	tw    *tar.Writer
+	mtime time.Time
+	dirs  map[string]bool
+}
+
+func (t *tarwriter) WriteFile(name string, size int64, src io.Reader) (err error) {
+	for _, dir := range tarx.Parents(name) {
+		if t.dirs[dir] {
+			continue
+		}
+		t.dirs[dir] = true
+
+		if err = t.tw.WriteHeader(tarx.Normalize(tarx.NewDirHeader(dir, t.mtime, 0755))); err != nil {
+			return err
+		}
+	}
+
+	return tarx.WriteFileToArchive(t.tw, tarx.Normalize(tarx.NewHeader(name, t.mtime, size, 0644)), src)
+}
+
+func (t *tarwriter) Close() error {
+	return t.tw.Close()
+}
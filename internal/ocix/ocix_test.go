@@ -0,0 +1,197 @@
+package ocix_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/egdaemon/egt/internal/ocix"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/stretchr/testify/require"
+)
+
+func layer(t *testing.T, codec tarx.Codec) (blob, uncompressed []byte) {
+	raw := bytes.Buffer{}
+	tw := tar.NewWriter(&raw)
+	require.NoError(t, tarx.WriteFileToArchive(tw, tarx.NewHeader("hello.txt", time.Unix(0, 0), 11, 0644), strings.NewReader("hello world")))
+	require.NoError(t, tw.Close())
+
+	compressed := bytes.Buffer{}
+	cw, err := codec.NewWriter(&compressed, tarx.LevelDefault)
+	require.NoError(t, err)
+	_, err = cw.Write(raw.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, cw.Close())
+	return compressed.Bytes(), raw.Bytes()
+}
+
+func digest(b []byte) string {
+	d := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(d[:])
+}
+
+func TestOcixLayer(t *testing.T) {
+	for _, codec := range []tarx.Codec{tarx.CodecNone, tarx.CodecGzip, tarx.CodecZstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			blob, uncompressed := layer(t, codec)
+			desc, diffid, err := Layer(bytes.NewReader(blob))
+			require.NoError(t, err)
+
+			mediatype, err := LayerMediaType(codec)
+			require.NoError(t, err)
+			require.Equal(t, Descriptor{MediaType: mediatype, Digest: digest(blob), Size: int64(len(blob))}, desc)
+			require.Equal(t, digest(uncompressed), diffid)
+		})
+	}
+}
+
+func TestOcixLayerInvalid(t *testing.T) {
+	blob, _ := layer(t, tarx.CodecXz)
+	_, _, err := Layer(bytes.NewReader(blob))
+	require.ErrorContains(t, err, "xz compressed layers are not supported by OCI images")
+
+	_, _, err = Layer(strings.NewReader(strings.Repeat("not a tar archive", 64)))
+	require.ErrorContains(t, err, "layer is not a tar archive")
+}
+
+func TestOcixParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm64/v8")
+	require.NoError(t, err)
+	require.Equal(t, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, p)
+
+	p, err = ParsePlatform("linux/amd64")
+	require.NoError(t, err)
+	require.Equal(t, Platform{OS: "linux", Architecture: "amd64"}, p)
+
+	for _, invalid := range []string{"", "linux", "linux/", "linux/arm/v7/extra"} {
+		_, err = ParsePlatform(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestOcixWriteLayout(t *testing.T) {
+	blob, _ := layer(t, tarx.CodecGzip)
+	ldesc, _, err := Layer(bytes.NewReader(blob))
+	require.NoError(t, err)
+
+	mdesc, manifest, err := Marshal(MediaTypeManifest, Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest, Layers: []Descriptor{ldesc}})
+	require.NoError(t, err)
+	mdesc.Annotations = map[string]string{AnnotationRefName: "latest"}
+
+	blobs := []Blob{
+		BytesBlob(mdesc, manifest),
+		BytesBlob(ldesc, blob),
+		BytesBlob(ldesc, blob),
+	}
+
+	root := t.TempDir()
+	index, err := WriteLayout(NewDirWriter(root, 0644, 0755), []Descriptor{mdesc}, blobs...)
+	require.NoError(t, err)
+
+	encoded, err := os.ReadFile(filepath.Join(root, "index.json"))
+	require.NoError(t, err)
+	require.Equal(t, index.Digest, digest(encoded))
+
+	decoded := Index{}
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, []Descriptor{mdesc}, decoded.Manifests)
+
+	layout, err := os.ReadFile(filepath.Join(root, "oci-layout"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"imageLayoutVersion":"1.0.0"}`, string(layout))
+
+	written, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(BlobPath(ldesc))))
+	require.NoError(t, err)
+	require.Equal(t, blob, written)
+
+	archived := bytes.Buffer{}
+	w := NewTarWriter(&archived, time.Unix(1700000000, 0))
+	tarindex, err := WriteLayout(w, []Descriptor{mdesc}, blobs...)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, index, tarindex)
+
+	names := []string{}
+	tr := tar.NewReader(&archived)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.True(t, time.Unix(1700000000, 0).Equal(hdr.ModTime))
+		names = append(names, hdr.Name)
+	}
+
+	require.Equal(t, []string{
+		"oci-layout",
+		"blobs/",
+		"blobs/sha256/",
+		BlobPath(mdesc),
+		BlobPath(ldesc),
+		"index.json",
+	}, names)
+}
+
+func TestOcixParseRepoTag(t *testing.T) {
+	repository, tag, err := ParseRepoTag("example.com:5000/team/app:1.0")
+	require.NoError(t, err)
+	require.Equal(t, "example.com:5000/team/app", repository)
+	require.Equal(t, "1.0", tag)
+
+	for _, invalid := range []string{"", "app", "app:", ":1.0", "example.com:5000/app", "app@sha256:abc"} {
+		_, _, err = ParseRepoTag(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestOcixWriteDockerArchive(t *testing.T) {
+	blob, _ := layer(t, tarx.CodecGzip)
+	ldesc, _, err := Layer(bytes.NewReader(blob))
+	require.NoError(t, err)
+
+	cdesc, config, err := Marshal(MediaTypeConfig, Image{OS: "linux", Architecture: "amd64", RootFS: RootFS{Type: "layers"}})
+	require.NoError(t, err)
+
+	archived := bytes.Buffer{}
+	w := NewTarWriter(&archived, time.Unix(0, 0))
+	require.NoError(t, WriteDockerArchive(w, cdesc, []Descriptor{ldesc}, []string{"example.com/app:1.0", "example.com/app:latest"}, BytesBlob(cdesc, config), BytesBlob(ldesc, blob)))
+	require.NoError(t, w.Close())
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(&archived)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = contents
+	}
+
+	require.Equal(t, config, files[BlobPath(cdesc)])
+	require.Equal(t, blob, files[BlobPath(ldesc)])
+
+	manifests := []DockerManifest{}
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifests))
+	require.Equal(t, []DockerManifest{{
+		Config:   BlobPath(cdesc),
+		RepoTags: []string{"example.com/app:1.0", "example.com/app:latest"},
+		Layers:   []string{BlobPath(ldesc)},
+	}}, manifests)
+
+	top := strings.TrimPrefix(ldesc.Digest, "sha256:")
+	require.JSONEq(t, `{"example.com/app":{"1.0":"`+top+`","latest":"`+top+`"}}`, string(files["repositories"]))
+
+	require.Error(t, WriteDockerArchive(NewTarWriter(io.Discard, time.Unix(0, 0)), cdesc, []Descriptor{ldesc}, []string{"app"}))
+}
@@ -0,0 +1,316 @@
+package provider
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// adoptedkey is the private state key recording the path of an archive adopted by an import.
+const adoptedkey = "adopted"
+
+// adopted returns the path of the archive adopted by an import, empty when
+// the resource generated the archive itself.
+func adopted(ctx context.Context, private interface {
+	GetKey(context.Context, string) ([]byte, diag.Diagnostics)
+}) (p string, diags diag.Diagnostics) {
+	encoded, diags := private.GetKey(ctx, adoptedkey)
+	if diags.HasError() || len(encoded) == 0 {
+		return "", diags
+	}
+
+	if err := json.Unmarshal(encoded, &p); err != nil {
+		diags.Append(errdiag("unable to decode adopted archive", err))
+	}
+
+	return p, diags
+}
+
+// adopt an existing archive on disk reconstructing the resource state from its
+// entries. regular files become base64 sources, links become symlink and
+// hardlink sources, device nodes become device sources, directories enable parent
+// directory generation with their permissions, metadata matching the defaults
+// of a source is left null so configuration omitting it does not produce a diff.
+// the manifest and content digest are those of the archive the reconstructed
+// sources produce, entries which can not be represented are omitted.
+func (r *ArchiveResource) adopt(ctx context.Context, p string) (data ArchiveResourceModel, diags diag.Diagnostics) {
+	var (
+		digest  = sha256.New()
+		adigest = sha256.New()
+		counter = &iox.Counter{}
+		encoded = strings.Builder{}
+		ts      time.Time
+		dirs    = []*tar.Header{}
+		seen    = map[string]bool{}
+		policy  = duplicateError
+	)
+
+	src, err := os.Open(p)
+	if err != nil {
+		diags.Append(errdiag("unable to open archive", errorsx.UserFriendly(err)))
+		return data, diags
+	}
+	defer src.Close()
+
+	b64 := base64.NewEncoder(base64.StdEncoding, &encoded)
+	cr, codec, err := tarx.Decompress(io.TeeReader(src, io.MultiWriter(adigest, counter, b64)))
+	if err != nil {
+		diags.Append(errdiag("unable to read archive", errorsx.UserFriendly(err)))
+		return data, diags
+	}
+	defer cr.Close()
+
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			diags.Append(errdiag("unable to read archive", errorsx.UserFriendly(err)))
+			return data, diags
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			dirs = append(dirs, hdr)
+			continue
+		case tar.TypeReg, tar.TypeSymlink, tar.TypeLink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		default:
+			diags.AddWarning("archive entry skipped", fmt.Sprintf("%s is not a regular file, link or device node and cannot be represented as a source", hdr.Name))
+			continue
+		}
+
+		if ts.IsZero() {
+			ts = hdr.ModTime
+		}
+
+		// tar extraction overwrites earlier entries with later ones.
+		location := normalizelocation(hdr.Name)
+		if seen[location] {
+			policy = duplicateLastWins
+		}
+		seen[location] = true
+
+		switch hdr.Typeflag {
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			data.Sources = append(data.Sources, adoptdevice(hdr, ts))
+			continue
+		case tar.TypeSymlink, tar.TypeLink:
+			data.Sources = append(data.Sources, adoptlink(hdr, ts))
+			continue
+		}
+
+		raw, err := io.ReadAll(tr)
+		if err != nil {
+			diags.Append(errdiag("unable to read archive", errorsx.UserFriendly(err)))
+			return data, diags
+		}
+
+		data.Sources = append(data.Sources, adoptsource(hdr, ts, raw))
+	}
+
+	// drain any trailing padding so the archive digest covers the entire file.
+	if _, err = io.Copy(io.Discard, src); err != nil {
+		diags.Append(errdiag("unable to read archive", errorsx.UserFriendly(err)))
+		return data, diags
+	}
+
+	if err = b64.Close(); err != nil {
+		diags.Append(errdiag("unable to encode archive", err))
+		return data, diags
+	}
+
+	data.DigestAlgorithm = types.StringValue(digestSHA256)
+	data.Timestamp = types.Int64Value(ts.UnixMilli())
+	data.Reproducible = types.BoolValue(false)
+	data.TimestampPolicy = types.StringValue(timestampOnChange)
+	data.SourceDateEpoch = types.Int64Null()
+	data.Compression = types.StringValue(string(codec))
+	data.CompressionLevel = types.Int64Null()
+	data.Mimetype = types.StringValue(codec.Mimetype())
+	data.OutputPath = types.StringValue(p)
+	data.OutputFilePerm = types.Int32Value(0600)
+	data.OutputDirPerm = types.Int32Value(0750)
+	data.StoreArchiveB64 = types.BoolValue(true)
+	data.ArchiveDigest = types.StringValue(hex.EncodeToString(adigest.Sum(nil)))
+	data.ArchiveSize = types.Int64Value(counter.N)
+	data.ArchiveB64 = types.StringValue(encoded.String())
+	data.OnDuplicate = types.StringValue(policy)
+	data.StrictLinks = types.BoolValue(false)
+	adoptdirs(&data, dirs...)
+
+	if info, err := os.Stat(p); err == nil {
+		data.OutputFilePerm = types.Int32Value(int32(info.Mode().Perm()))
+	}
+
+	if data.Manifest, err = manifestof(ctx, &data); err != nil {
+		diags.Append(errdiag("unable to record manifest", err))
+		return data, diags
+	}
+
+	members, err := resolve(ctx, ts, &data)
+	if err != nil {
+		diags.Append(errdiag("unable to compute content digest", err))
+		return data, diags
+	}
+	defer closemembers(members...)
+
+	for _, e := range members {
+		if e.dropped || e.open == nil {
+			continue
+		}
+
+		if err = e.hash(digest); err != nil {
+			diags.Append(errdiag("unable to compute content digest", err))
+			return data, diags
+		}
+	}
+
+	data.ContentDigest = types.StringValue(hex.EncodeToString(digest.Sum(nil)))
+	data.Digest = data.ContentDigest
+
+	return data, diags
+}
+
+// adoptdirs enables parent directory generation when the archive contains
+// the parents of its sources. the permission of the first becomes
+// directory_perm and those differing from it directory_perms. directories
+// which are not the parent of a source can not be represented and are omitted.
+func adoptdirs(data *ArchiveResourceModel, dirs ...*tar.Header) {
+	var (
+		parents   = map[string]bool{}
+		overrides = map[string]attr.Value{}
+	)
+
+	data.ParentDirs = types.BoolValue(false)
+	data.DirPerm = types.Int32Value(0755)
+	data.DirPerms = types.MapNull(types.Int32Type)
+
+	for _, v := range data.Sources {
+		for _, dir := range tarx.Parents(v.location()) {
+			parents[dir] = true
+		}
+	}
+
+	for _, hdr := range dirs {
+		dir := normalizelocation(hdr.Name)
+		if !parents[dir] {
+			continue
+		}
+
+		mode := int32(hdr.Mode & 07777)
+		if !data.ParentDirs.ValueBool() {
+			data.ParentDirs = types.BoolValue(true)
+			data.DirPerm = types.Int32Value(mode)
+		}
+
+		if mode != data.DirPerm.ValueInt32() {
+			overrides[dir] = types.Int32Value(mode)
+		}
+	}
+
+	if len(overrides) > 0 {
+		data.DirPerms = types.MapValueMust(types.Int32Type, overrides)
+	}
+}
+
+func adoptsource(hdr *tar.Header, ts time.Time, raw []byte) *SourceModel {
+	src := newsource()
+	contentdigest := sha256.Sum256(raw)
+	encoded := hex.EncodeToString(contentdigest[:])
+
+	src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString(raw))
+	src.Location = types.StringValue(normalizelocation(hdr.Name))
+	src.Digest = types.StringValue(encoded)
+	src.Digests = digestsvalue(map[string]string{src.location(): encoded})
+
+	return adoptmetadata(src, hdr, ts, 0600)
+}
+
+func adoptlink(hdr *tar.Header, ts time.Time) *SourceModel {
+	src := newsource()
+	target, perm := hdr.Linkname, int32(0777)
+	if hdr.Typeflag == tar.TypeLink {
+		target, perm = normalizelocation(hdr.Linkname), 0600
+	}
+
+	linkdigest := sha256.Sum256([]byte(target))
+	encoded := hex.EncodeToString(linkdigest[:])
+
+	src.Location = types.StringValue(normalizelocation(hdr.Name))
+	src.Digest = types.StringValue(encoded)
+	src.Digests = digestsvalue(map[string]string{src.location(): encoded})
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		src.Symlink = types.StringValue(target)
+	} else {
+		src.Hardlink = types.StringValue(target)
+	}
+
+	return adoptmetadata(src, hdr, ts, perm)
+}
+
+func adoptdevice(hdr *tar.Header, ts time.Time) *SourceModel {
+	src := newsource()
+	e := entry{header: hdr}
+	digest, _ := e.digest()
+
+	src.Device = types.StringValue(tarx.TypeName(hdr.Typeflag))
+	src.Location = types.StringValue(normalizelocation(hdr.Name))
+	src.Digest = types.StringValue(digest)
+	src.Digests = digestsvalue(map[string]string{src.location(): digest})
+
+	if hdr.Devmajor != 0 {
+		src.Major = types.Int64Value(hdr.Devmajor)
+	}
+
+	if hdr.Devminor != 0 {
+		src.Minor = types.Int64Value(hdr.Devminor)
+	}
+
+	return adoptmetadata(src, hdr, ts, 0600)
+}
+
+// adoptmetadata records the header metadata that differs from the defaults of the source.
+func adoptmetadata(src *SourceModel, hdr *tar.Header, ts time.Time, perm int32) *SourceModel {
+	if mode := int32(hdr.Mode & 07777); mode != perm {
+		src.Perm = types.Int32Value(mode)
+	}
+
+	if hdr.Uid != 0 {
+		src.Uid = types.Int64Value(int64(hdr.Uid))
+	}
+
+	if hdr.Gid != 0 {
+		src.Gid = types.Int64Value(int64(hdr.Gid))
+	}
+
+	if hdr.Uname != "" {
+		src.Uname = types.StringValue(hdr.Uname)
+	}
+
+	if hdr.Gname != "" {
+		src.Gname = types.StringValue(hdr.Gname)
+	}
+
+	if !hdr.ModTime.Equal(ts) {
+		src.Mtime = types.Int64Value(hdr.ModTime.UnixMilli())
+	}
+
+	return src
+}
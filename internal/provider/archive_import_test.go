@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/stretchr/testify/require"
+)
+
+// importarchive imports the archive through the provider server, as terraform
+// import does, returning the imported state along with its private state.
+func importarchive(t *testing.T, p string) (tfsdk.State, []byte) {
+	ctx := context.Background()
+	schemaresp := &resource.SchemaResponse{}
+	NewTarResource().Schema(ctx, resource.SchemaRequest{}, schemaresp)
+	typ := schemaresp.Schema.Type().TerraformType(ctx)
+
+	server := providerserver.NewProtocol6(New("test")())()
+	imported, err := server.ImportResourceState(ctx, &tfprotov6.ImportResourceStateRequest{TypeName: "eg_tar", ID: p})
+	require.NoError(t, err)
+	require.Empty(t, imported.Diagnostics)
+	require.Len(t, imported.ImportedResources, 1)
+
+	read, err := server.ReadResource(ctx, &tfprotov6.ReadResourceRequest{
+		TypeName:     "eg_tar",
+		CurrentState: imported.ImportedResources[0].State,
+		Private:      imported.ImportedResources[0].Private,
+	})
+	require.NoError(t, err)
+	require.Empty(t, read.Diagnostics)
+
+	state, err := read.NewState.Unmarshal(typ)
+	require.NoError(t, err)
+	require.False(t, state.IsNull(), "imported archive must not be drifted")
+
+	return tfsdk.State{Schema: schemaresp.Schema, Raw: state}, read.Private
+}
+
+func TestArchiveResourceImport(t *testing.T) {
+	ctx := context.Background()
+	r := &ArchiveResource{}
+	output := filepath.Join(t.TempDir(), "release.tar.gz")
+
+	source := func(contents, location string, perm int32) *SourceModel {
+		src := newsource()
+		src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(contents)))
+		src.Location = types.StringValue(location)
+		if perm != 0 {
+			src.Perm = types.Int32Value(perm)
+		}
+		return src
+	}
+
+	link := newsource()
+	link.Symlink = types.StringValue("usr/bin/agent")
+	link.Location = types.StringValue("agent")
+
+	console := newsource()
+	console.Device = types.StringValue("char")
+	console.Major = types.Int64Value(5)
+	console.Minor = types.Int64Value(1)
+	console.Location = types.StringValue("dev/console")
+
+	ts := time.UnixMilli(1700000000123)
+	original := ArchiveResourceModel{
+		Compression:     types.StringValue(string(tarx.CodecGzip)),
+		OutputPath:      types.StringValue(output),
+		OutputFilePerm:  types.Int32Value(0600),
+		OutputDirPerm:   types.Int32Value(0750),
+		StoreArchiveB64: types.BoolValue(true),
+		Sources: []*SourceModel{
+			source("#!/bin/sh\n", "usr/bin/agent", 0755),
+			source("key: value\n", "etc/agent.yaml", 0),
+			link,
+			console,
+		},
+	}
+	require.NoError(t, r.build(ctx, ts, &original, true))
+
+	state, _ := importarchive(t, output)
+
+	var imported ArchiveResourceModel
+	require.False(t, state.Get(ctx, &imported).HasError())
+
+	require.Equal(t, ts.Truncate(time.Second).UnixMilli(), imported.Timestamp.ValueInt64())
+	require.Equal(t, original.Digest, imported.Digest)
+	require.Equal(t, original.ArchiveDigest, imported.ArchiveDigest)
+	require.Equal(t, original.ArchiveSize, imported.ArchiveSize)
+	require.Equal(t, original.ArchiveB64, imported.ArchiveB64)
+	require.Equal(t, original.Compression, imported.Compression)
+	require.Equal(t, original.Manifest, imported.Manifest)
+	require.Equal(t, output, imported.OutputPath.ValueString())
+	require.Len(t, imported.Sources, 4)
+	for i, src := range original.Sources {
+		require.Equal(t, src.Base64, imported.Sources[i].Base64)
+		require.Equal(t, src.Symlink, imported.Sources[i].Symlink)
+		require.Equal(t, src.Device, imported.Sources[i].Device)
+		require.Equal(t, src.Major, imported.Sources[i].Major)
+		require.Equal(t, src.Minor, imported.Sources[i].Minor)
+		require.Equal(t, src.Location, imported.Sources[i].Location)
+		require.Equal(t, src.Perm, imported.Sources[i].Perm)
+		require.Equal(t, src.Uid, imported.Sources[i].Uid)
+		require.Equal(t, src.Mtime, imported.Sources[i].Mtime)
+		require.Equal(t, src.Digest, imported.Sources[i].Digest)
+	}
+
+	reason, err := r.drifted(&imported)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+}
+
+func TestArchiveResourceImportPlan(t *testing.T) {
+	ctx := context.Background()
+	output := filepath.Join(t.TempDir(), "release.tar")
+	ts := time.Unix(1700000000, 0)
+
+	encoded := bytes.Buffer{}
+	tw := tar.NewWriter(&encoded)
+	for _, hdr := range []*tar.Header{
+		tarx.NewDirHeader("./", ts, 0755),
+		tarx.NewDirHeader("./etc/", ts, 0755),
+		tarx.NewHeader("./etc/agent.yaml", ts, 11, 0644),
+		tarx.NewDirHeader("./usr/", ts, 0755),
+		tarx.NewDirHeader("./usr/bin/", ts, 0700),
+		tarx.NewHeader("./usr/bin/agent", ts, 10, 0755),
+		tarx.NewDirHeader("./var/empty/", ts, 0755),
+		{Typeflag: tar.TypeSymlink, Name: "./agent", Linkname: "usr/bin/agent", Mode: 0777, ModTime: ts},
+	} {
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := io.WriteString(tw, map[string]string{"./etc/agent.yaml": "key: value\n", "./usr/bin/agent": "#!/bin/sh\n"}[hdr.Name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, os.WriteFile(output, encoded.Bytes(), 0600))
+
+	state, private := importarchive(t, output)
+	schemaresp := &resource.SchemaResponse{}
+	NewTarResource().Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	var imported ArchiveResourceModel
+	require.False(t, state.Get(ctx, &imported).HasError())
+	require.Equal(t, map[string]attr.Value{"usr/bin": types.Int32Value(0700)}, imported.DirPerms.Elements())
+
+	var manifest []ManifestModel
+	require.False(t, imported.Manifest.ElementsAs(ctx, &manifest, false).HasError())
+	locations := []string{}
+	for _, m := range manifest {
+		locations = append(locations, m.Location.ValueString())
+	}
+	require.Equal(t, []string{"etc", "etc/agent.yaml", "usr", "usr/bin", "usr/bin/agent", "agent"}, locations)
+
+	// the configuration matching the import sets every attribute that is not computed.
+	config, err := tftypes.Transform(state.Raw, func(p *tftypes.AttributePath, v tftypes.Value) (tftypes.Value, error) {
+		a, err := schemaresp.Schema.AttributeAtTerraformPath(ctx, p)
+		if err != nil || !a.IsComputed() || a.IsOptional() {
+			return v, nil
+		}
+
+		return tftypes.NewValue(v.Type(), nil), nil
+	})
+	require.NoError(t, err)
+
+	typ := state.Schema.Type().TerraformType(ctx)
+	dynamic := func(v tftypes.Value) *tfprotov6.DynamicValue {
+		dv, err := tfprotov6.NewDynamicValue(typ, v)
+		require.NoError(t, err)
+		return &dv
+	}
+
+	server := providerserver.NewProtocol6(New("test")())()
+	planned, err := server.PlanResourceChange(ctx, &tfprotov6.PlanResourceChangeRequest{
+		TypeName:         "eg_tar",
+		PriorState:       dynamic(state.Raw),
+		ProposedNewState: dynamic(state.Raw),
+		Config:           dynamic(config),
+		PriorPrivate:     private,
+	})
+	require.NoError(t, err)
+	require.Empty(t, planned.Diagnostics)
+	require.Empty(t, planned.RequiresReplace)
+
+	plan, err := planned.PlannedState.Unmarshal(typ)
+	require.NoError(t, err)
+	diffs, err := state.Raw.Diff(plan)
+	require.NoError(t, err)
+	require.Empty(t, diffs)
+
+	// destroying the resource leaves the adopted archive in place.
+	destroyed, err := server.ApplyResourceChange(ctx, &tfprotov6.ApplyResourceChangeRequest{
+		TypeName:       "eg_tar",
+		PriorState:     dynamic(state.Raw),
+		PlannedState:   dynamic(tftypes.NewValue(typ, nil)),
+		Config:         dynamic(tftypes.NewValue(typ, nil)),
+		PlannedPrivate: private,
+	})
+	require.NoError(t, err)
+	require.Empty(t, destroyed.Diagnostics)
+	require.FileExists(t, output)
+}
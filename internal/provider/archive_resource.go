@@ -2,82 +2,383 @@ package provider
 
 import (
 	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/egdaemon/egt/internal/errorsx"
 	"github.com/egdaemon/egt/internal/iox"
 	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/egdaemon/egt/internal/zipx"
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/mapvalidator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
-	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
-	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int32default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
-// reference resource: https://github.com/hashicorp/terraform-provider-local/blob/main/internal/provider/resource_local_file.go
-type SourceModel struct {
-	Base64   types.String `tfsdk:"base64"`
-	Location types.String `tfsdk:"location"`
-	Perm     types.Int32  `tfsdk:"perm"`
-	Digest   types.String `tfsdk:"digest"`
+// ArchiveResourceModel describes an archive; the sources of its entries, how
+// they are written and the digests of the archive produced from them.
+type ArchiveResourceModel struct {
+	Digest           types.String   `tfsdk:"digest"`
+	ContentDigest    types.String   `tfsdk:"content_digest"`
+	DigestAlgorithm  types.String   `tfsdk:"digest_algorithm"`
+	Sources          []*SourceModel `tfsdk:"source"`
+	Timestamp        types.Int64    `tfsdk:"timestamp"`
+	TimestampPolicy  types.String   `tfsdk:"timestamp_policy"`
+	Reproducible     types.Bool     `tfsdk:"reproducible"`
+	SourceDateEpoch  types.Int64    `tfsdk:"source_date_epoch"`
+	Compression      types.String   `tfsdk:"compression"`
+	CompressionLevel types.Int64    `tfsdk:"compression_level"`
+	Mimetype         types.String   `tfsdk:"mimetype"`
+	OutputPath       types.String   `tfsdk:"output_path"`
+	OutputFilePerm   types.Int32    `tfsdk:"output_file_permission"`
+	OutputDirPerm    types.Int32    `tfsdk:"output_directory_permission"`
+	StoreArchiveB64  types.Bool     `tfsdk:"store_archiveb64"`
+	ArchiveDigest    types.String   `tfsdk:"archive_digest"`
+	ArchiveSize      types.Int64    `tfsdk:"archive_size"`
+	ArchiveB64       types.String   `tfsdk:"archiveb64"`
+	ParentDirs       types.Bool     `tfsdk:"parent_directories"`
+	DirPerm          types.Int32    `tfsdk:"directory_perm"`
+	DirPerms         types.Map      `tfsdk:"directory_perms"`
+	OnDuplicate      types.String   `tfsdk:"on_duplicate"`
+	Manifest         types.List     `tfsdk:"manifest"`
+	Limits           *LimitsModel   `tfsdk:"limits"`
+	StrictLinks      types.Bool     `tfsdk:"strict_links"`
 }
 
-// ExampleResourceModel describes the resource data model.
-type ArchiveResourceModel struct {
-	Digest     types.String   `tfsdk:"digest"`
-	Sources    []*SourceModel `tfsdk:"source"`
-	Timestamp  types.Int64    `tfsdk:"timestamp"`
-	ArchiveB64 types.String   `tfsdk:"archiveb64"`
+// codec used to compress the archive along with its level, defaulting to the codec of the format.
+func (t *ArchiveResourceModel) codec(f *format) (string, int) {
+	codec, level := f.compression, tarx.LevelDefault
+	if !t.Compression.IsNull() && !t.Compression.IsUnknown() {
+		codec = t.Compression.ValueString()
+	}
+
+	if !t.CompressionLevel.IsNull() && !t.CompressionLevel.IsUnknown() {
+		level = int(t.CompressionLevel.ValueInt64())
+	}
+
+	return codec, level
+}
+
+// unknown reports if any of the attributes determining the entries of the archive are unknown.
+func (t *ArchiveResourceModel) unknown() bool {
+	for _, v := range t.Sources {
+		if v.unknown() {
+			return true
+		}
+	}
+
+	return t.Reproducible.IsUnknown() || t.OnDuplicate.IsUnknown() || t.StrictLinks.IsUnknown() ||
+		t.ParentDirs.IsUnknown() || t.DirPerm.IsUnknown() || !known(t.DirPerms)
+}
+
+// timestamp of the archive, the planned timestamp when known otherwise the
+// source date epoch for fixed timestamps or the current time.
+func (t *ArchiveResourceModel) timestamp() (time.Time, error) {
+	switch {
+	case !t.Timestamp.IsNull() && !t.Timestamp.IsUnknown():
+		return time.UnixMilli(t.Timestamp.ValueInt64()), nil
+	case t.Reproducible.ValueBool() || t.TimestampPolicy.ValueString() == timestampFixed:
+		return sourcedateepoch(t.SourceDateEpoch)
+	default:
+		return time.Now(), nil
+	}
 }
 
 func NewTarResource() resource.Resource {
-	return &ArchiveResource{}
+	return &ArchiveResource{format: formattar}
+}
+
+func NewZipResource() resource.Resource {
+	return &ArchiveResource{format: formatzip}
+}
+
+func NewCpioResource() resource.Resource {
+	return &ArchiveResource{format: formatcpio}
+}
+
+// ArchiveResource defines the resource implementation for an archive
+type ArchiveResource struct {
+	// provider configuration, nil until the resource is configured.
+	provider *providerdata
+	// format of the archive, defaults to tar.
+	format *format
 }
 
-// ArchiveResource defines the resource implementation for a tar archive
-type ArchiveResource struct{}
+// archiveformat of the resource.
+func (r *ArchiveResource) archiveformat() *format {
+	if r.format == nil {
+		return formattar
+	}
+
+	return r.format
+}
+
+// limited associates the stricter of the provider's and the archive's limits with the context.
+func (r *ArchiveResource) limited(ctx context.Context, data *ArchiveResourceModel) context.Context {
+	return r.provider.limited(ctx, data)
+}
 
 func (r *ArchiveResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
-	resp.TypeName = req.ProviderTypeName + "_tar"
+	resp.TypeName = req.ProviderTypeName + "_" + r.archiveformat().name
 }
 
 func (r *ArchiveResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	f := r.archiveformat()
 	resp.Schema = schema.Schema{
-		MarkdownDescription: "creates a tar archive",
+		MarkdownDescription: f.description,
 		Blocks: map[string]schema.Block{
 			"source": schema.ListNestedBlock{
 				NestedObject: schema.NestedBlockObject{
 					Attributes: map[string]schema.Attribute{
 						"base64": schema.StringAttribute{
-							Required: true,
+							MarkdownDescription: "base64 encoded content of the file",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ExactlyOneOf(
+									path.MatchRelative().AtParent().AtName("base64"),
+									path.MatchRelative().AtParent().AtName("path"),
+									path.MatchRelative().AtParent().AtName("directory"),
+									path.MatchRelative().AtParent().AtName("symlink"),
+									path.MatchRelative().AtParent().AtName("hardlink"),
+									path.MatchRelative().AtParent().AtName("device"),
+									path.MatchRelative().AtParent().AtName("whiteout"),
+									path.MatchRelative().AtParent().AtName("opaque"),
+									path.MatchRelative().AtParent().AtName("template"),
+									path.MatchRelative().AtParent().AtName("archive"),
+									path.MatchRelative().AtParent().AtName("archive_base64"),
+								),
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"path": schema.StringAttribute{
+							MarkdownDescription: "path to a local file whose content is streamed into the archive at apply time",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"directory": schema.StringAttribute{
+							MarkdownDescription: "path to a local directory whose files are added to the archive in lexical order, directories themselves are not emitted",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ConflictsWith(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"archive": schema.StringAttribute{
+							MarkdownDescription: "path to a local tar archive, optionally compressed, whose entries are copied into the archive preserving their headers. entries from other kinds of sources take precedence over archive entries sharing their location, on_duplicate applies between archives",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ConflictsWith(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"archive_base64": schema.StringAttribute{
+							MarkdownDescription: "base64 encoded tar archive, optionally compressed, handled identically to archive",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ConflictsWith(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"strip_prefix": schema.StringAttribute{
+							MarkdownDescription: "leading directory removed from the locations of archive entries, entries outside of it are skipped",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.AtLeastOneOf(
+									path.MatchRelative().AtParent().AtName("archive"),
+									path.MatchRelative().AtParent().AtName("archive_base64"),
+								),
+								LocationValidator(),
+							},
+						},
+						"symlink": schema.StringAttribute{
+							MarkdownDescription: "target of a symbolic link placed at location, e.g. `usr/lib` for `lib -> usr/lib`",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.LengthAtLeast(1),
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"hardlink": schema.StringAttribute{
+							MarkdownDescription: "location of an entry earlier in the archive that the hard link placed at location references",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("location")),
+								LocationValidator(),
+							},
+						},
+						"device": schema.StringAttribute{
+							MarkdownDescription: "type of the device node placed at location; char, block or fifo",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.OneOf(devicenames()...),
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"major": schema.Int64Attribute{
+							MarkdownDescription: "major number of the device node, defaults to 0",
+							Optional:            true,
+							Validators: []validator.Int64{
+								int64validator.AtLeast(0),
+								int64validator.AlsoRequires(path.MatchRelative().AtParent().AtName("device")),
+							},
+						},
+						"minor": schema.Int64Attribute{
+							MarkdownDescription: "minor number of the device node, defaults to 0",
+							Optional:            true,
+							Validators: []validator.Int64{
+								int64validator.AtLeast(0),
+								int64validator.AlsoRequires(path.MatchRelative().AtParent().AtName("device")),
+							},
+						},
+						"whiteout": schema.StringAttribute{
+							MarkdownDescription: "location of a file or directory removed from the lower layers of an OCI image, emitted as a `.wh.` prefixed whiteout entry",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ConflictsWith(path.MatchRelative().AtParent().AtName("location")),
+								LocationValidator(),
+							},
+						},
+						"opaque": schema.StringAttribute{
+							MarkdownDescription: "location of a directory whose contents in the lower layers of an OCI image are hidden, emitted as a `.wh..wh..opq` entry within the directory",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ConflictsWith(path.MatchRelative().AtParent().AtName("location")),
+								LocationValidator(),
+							},
+						},
+						"template": schema.StringAttribute{
+							MarkdownDescription: "go `text/template` body rendered with vars during apply, only the digest of the rendered content is recorded in the plan. referencing a missing var is an error",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("location")),
+							},
+						},
+						"vars": schema.MapAttribute{
+							MarkdownDescription: "values available to the template, e.g. `{{ .name }}`",
+							ElementType:         types.StringType,
+							Optional:            true,
+							Validators: []validator.Map{
+								mapvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("template")),
+							},
+						},
+						"include": schema.ListAttribute{
+							MarkdownDescription: "glob patterns, relative to the directory or strip_prefix of the archive, of the files to include. `**` matches any number of directories. defaults to every file",
+							ElementType:         types.StringType,
+							Optional:            true,
+							Validators: []validator.List{
+								RequiresAnyOf(
+									path.MatchRelative().AtParent().AtName("directory"),
+									path.MatchRelative().AtParent().AtName("archive"),
+									path.MatchRelative().AtParent().AtName("archive_base64"),
+								),
+							},
+						},
+						"exclude": schema.ListAttribute{
+							MarkdownDescription: "glob patterns, relative to the directory or strip_prefix of the archive, of the files and directories to exclude",
+							ElementType:         types.StringType,
+							Optional:            true,
+							Validators: []validator.List{
+								RequiresAnyOf(
+									path.MatchRelative().AtParent().AtName("directory"),
+									path.MatchRelative().AtParent().AtName("archive"),
+									path.MatchRelative().AtParent().AtName("archive_base64"),
+								),
+							},
+						},
+						"ignore_files": schema.ListAttribute{
+							MarkdownDescription: "names of gitignore style files (e.g. `.gitignore`, `.dockerignore`) to honor while walking the directory",
+							ElementType:         types.StringType,
+							Optional:            true,
+							Validators: []validator.List{
+								listvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("directory")),
+							},
+						},
+						"prefix": schema.StringAttribute{
+							MarkdownDescription: "location within the archive to place the contents of the directory or archive",
+							Optional:            true,
+							Validators: []validator.String{
+								RequiresAnyOf(
+									path.MatchRelative().AtParent().AtName("directory"),
+									path.MatchRelative().AtParent().AtName("archive"),
+									path.MatchRelative().AtParent().AtName("archive_base64"),
+								),
+								LocationValidator(),
+							},
 						},
 						"location": schema.StringAttribute{
-							MarkdownDescription: "location to place the file within the archive",
-							Required:            true,
+							MarkdownDescription: "location to place the file within the archive, required for base64, path, symlink, hardlink, device and template sources. must be relative, without a trailing slash and may not contain `..`",
+							Optional:            true,
+							Validators: []validator.String{
+								LocationValidator(),
+							},
 						},
 						"perm": schema.Int32Attribute{
-							MarkdownDescription: "permission bits within the archive for the file, defaults to read/write for the user only",
+							MarkdownDescription: "permission bits within the archive for the file, defaults to read/write for the user only. for directories defaults to the permissions of each file on disk",
 							Optional:            true,
 							// Default:             int32default.StaticInt32(0600),
 						},
+						"uid": schema.Int64Attribute{
+							MarkdownDescription: "numeric user id owning the file within the archive, defaults to 0",
+							Optional:            true,
+						},
+						"gid": schema.Int64Attribute{
+							MarkdownDescription: "numeric group id owning the file within the archive, defaults to 0",
+							Optional:            true,
+						},
+						"uname": schema.StringAttribute{
+							MarkdownDescription: "user name owning the file within the archive",
+							Optional:            true,
+						},
+						"gname": schema.StringAttribute{
+							MarkdownDescription: "group name owning the file within the archive",
+							Optional:            true,
+						},
+						"mtime": schema.Int64Attribute{
+							MarkdownDescription: "modification time (unix milliseconds) of the file within the archive, defaults to the archive timestamp",
+							Optional:            true,
+						},
+						"method": schema.StringAttribute{
+							MarkdownDescription: "compression method of the files the source contributes to a zip archive, store or deflate. e.g. store already compressed content or files readers expect uncompressed. defaults to the archive's compression, only supported by zip archives",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.OneOf(methodnames(zipx.Methods()...)...),
+							},
+						},
 						"digest": schema.StringAttribute{
 							MarkdownDescription: "archive digest used to determine if content has changed",
 							Computed:            true,
 							Optional:            false,
 							Required:            false,
-							PlanModifiers: []planmodifier.String{
-								UseSHA256OfAttribute("base64"),
-							},
+						},
+						"digests": schema.MapAttribute{
+							MarkdownDescription: "digest of every file the source contributes to the archive keyed by location",
+							ElementType:         types.StringType,
+							Computed:            true,
 						},
 					},
 				},
@@ -85,99 +386,809 @@ func (r *ArchiveResource) Schema(ctx context.Context, req resource.SchemaRequest
 		},
 		Attributes: map[string]schema.Attribute{
 			"timestamp": schema.Int64Attribute{
-				MarkdownDescription: "timestamp files should be given within the archive, this value is set on creation and when the digest of the source content changes.",
+				MarkdownDescription: "timestamp (unix milliseconds) files are given within the archive as determined by timestamp_policy",
+				Computed:            true,
+			},
+			"timestamp_policy": schema.StringAttribute{
+				MarkdownDescription: "how the timestamp is determined; `on-change` sets it on creation and whenever the digest of a source changes, `fixed` uses the source date epoch, `per-apply` sets it on every apply that changes the archive and `content-derived` uses the newest modification time of the files read from disk. reproducible archives always use the source date epoch. defaults to on-change",
+				Optional:            true,
 				Computed:            true,
-				PlanModifiers: []planmodifier.Int64{
-					int64planmodifier.UseStateForUnknown(),
+				Default:             stringdefault.StaticString(timestampOnChange),
+				Validators: []validator.String{
+					stringvalidator.OneOf(timestampOnChange, timestampFixed, timestampPerApply, timestampContentDerived),
 				},
 			},
+			"reproducible": schema.BoolAttribute{
+				MarkdownDescription: "produce bit for bit reproducible archives; entries are sorted by location, headers are normalized, ownership not set on a source is reset to root and the timestamp is the source date epoch",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"source_date_epoch": schema.Int64Attribute{
+				MarkdownDescription: "timestamp (unix seconds) of reproducible archives and the fixed timestamp policy, defaults to the SOURCE_DATE_EPOCH environment variable or the unix epoch",
+				Optional:            true,
+			},
+			"on_duplicate": schema.StringAttribute{
+				MarkdownDescription: "how entries sharing a location are handled; error, first-wins or last-wins. defaults to error",
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString(duplicateError),
+				Validators: []validator.String{
+					stringvalidator.OneOf(duplicateError, duplicateFirstWins, duplicateLastWins),
+				},
+			},
+			"strict_links": schema.BoolAttribute{
+				MarkdownDescription: "reject symlinks, including those found within directories, that are absolute or resolve outside of the archive root",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"parent_directories": schema.BoolAttribute{
+				MarkdownDescription: "emit a directory entry for every parent of an entry's location before the entry itself",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"directory_perm": schema.Int32Attribute{
+				MarkdownDescription: "permission bits of generated parent directories, defaults to 0755",
+				Optional:            true,
+				Computed:            true,
+				Default:             int32default.StaticInt32(0755),
+			},
+			"directory_perms": schema.MapAttribute{
+				MarkdownDescription: "permission bits of specific generated parent directories keyed by location, overriding directory_perm",
+				ElementType:         types.Int32Type,
+				Optional:            true,
+			},
 			"digest": schema.StringAttribute{
-				MarkdownDescription: "archive digest used to determine if content has changed",
+				MarkdownDescription: "digest of the concatenated content of the archive's files, identical to content_digest",
+				DeprecationMessage:  "use content_digest, or archive_digest for the digest of the archive bytes",
+				Computed:            true,
+			},
+			"content_digest": schema.StringAttribute{
+				MarkdownDescription: "digest of the concatenated content of the archive's files using digest_algorithm, unaffected by headers and compression",
+				Computed:            true,
+			},
+			"digest_algorithm": schema.StringAttribute{
+				MarkdownDescription: "algorithm of content_digest, archive_digest and the digest of each source; sha256, sha384 or sha512. defaults to sha256",
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString(digestSHA256),
+				Validators: []validator.String{
+					stringvalidator.OneOf(digestSHA256, digestSHA384, digestSHA512),
+				},
+			},
+			"compression": schema.StringAttribute{
+				MarkdownDescription: f.compressiondescription,
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString(f.compression),
+				Validators: []validator.String{
+					stringvalidator.OneOf(f.compressions...),
+				},
+			},
+			"compression_level": schema.Int64Attribute{
+				MarkdownDescription: f.leveldescription,
+				Optional:            true,
+			},
+			"mimetype": schema.StringAttribute{
+				MarkdownDescription: "mimetype of the archive as determined by the compression codec",
+				Computed:            true,
+			},
+			"output_path": schema.StringAttribute{
+				MarkdownDescription: "path the archive is atomically written to, the resource is recreated when the file is removed or modified",
+				Optional:            true,
+			},
+			"output_file_permission": schema.Int32Attribute{
+				MarkdownDescription: "permission bits of the file written to output_path, defaults to read/write for the user only",
+				Optional:            true,
+				Computed:            true,
+				Default:             int32default.StaticInt32(0600),
+			},
+			"output_directory_permission": schema.Int32Attribute{
+				MarkdownDescription: "permission bits of any directories created for output_path",
+				Optional:            true,
+				Computed:            true,
+				Default:             int32default.StaticInt32(0750),
+			},
+			"store_archiveb64": schema.BoolAttribute{
+				MarkdownDescription: "store the base64 encoded archive in state as archiveb64, the encoded archive is held in memory while generating. disable when using output_path with large archives to stream the archive with bounded memory",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(true),
+			},
+			"archive_digest": schema.StringAttribute{
+				MarkdownDescription: "digest of the final compressed archive bytes using digest_algorithm, e.g. matching `sha256sum` of output_path",
 				Computed:            true,
 			},
+			"archive_size": schema.Int64Attribute{
+				MarkdownDescription: "size of the archive in bytes",
+				Computed:            true,
+			},
+			"manifest": schema.ListNestedAttribute{
+				MarkdownDescription: "every entry within the archive in the order they are written, including generated parent directories",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"location": schema.StringAttribute{
+							MarkdownDescription: "location of the entry within the archive",
+							Computed:            true,
+						},
+						"size": schema.Int64Attribute{
+							MarkdownDescription: "size of the entry's content in bytes",
+							Computed:            true,
+						},
+						"mode": schema.Int32Attribute{
+							MarkdownDescription: "permission bits of the entry",
+							Computed:            true,
+						},
+						"type": schema.StringAttribute{
+							MarkdownDescription: "type of the entry; file, directory, symlink, hardlink, char, block or fifo",
+							Computed:            true,
+						},
+						"sha256": schema.StringAttribute{
+							MarkdownDescription: "sha256 of the entry's content, of the target for links and null for everything else",
+							Computed:            true,
+						},
+					},
+				},
+			},
+			"limits": limitsResourceSchema(),
 			"archiveb64": schema.StringAttribute{
 				Computed:            true,
-				MarkdownDescription: "base64 encoded contents of the archive",
+				MarkdownDescription: "base64 encoded contents of the archive, null when store_archiveb64 is disabled",
 			},
 		},
 	}
 }
 
+func (r *ArchiveResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var (
+		data ArchiveResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.validate(ctx, &data, &resp.Diagnostics)
+}
+
+// validate the configuration of the archive.
+func (r *ArchiveResource) validate(ctx context.Context, data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	f := r.archiveformat()
+	validateduplicates(data, diags)
+	validatelinks(data, diags)
+	validatetemplates(ctx, data, diags)
+	validateformat(f, data, diags)
+
+	if data.Compression.IsUnknown() || data.CompressionLevel.IsNull() || data.CompressionLevel.IsUnknown() {
+		return
+	}
+
+	codec, level := data.codec(f)
+	if lowest, highest := f.levels(codec); level < lowest || level > highest {
+		diags.AddAttributeError(
+			path.Root("compression_level"),
+			"invalid compression level",
+			fmt.Sprintf("%s compression accepts levels between %d and %d, got %d", codec, lowest, highest, level),
+		)
+	}
+}
+
+// validateduplicates reports sources sharing a location when duplicates are an error.
+// locations produced by directories are only known during apply.
+func validateduplicates(data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	if data.OnDuplicate.IsUnknown() || (!data.OnDuplicate.IsNull() && data.OnDuplicate.ValueString() != duplicateError) {
+		return
+	}
+
+	seen := map[string]int{}
+	for i, v := range data.Sources {
+		if v.Location.IsNull() || v.Location.IsUnknown() {
+			continue
+		}
+
+		location := v.location()
+		if prior, ok := seen[location]; ok {
+			diags.AddAttributeError(
+				path.Root("source").AtListIndex(i).AtName("location"),
+				"duplicate location",
+				fmt.Sprintf("%s is already used by source %d, set on_duplicate to first-wins or last-wins to allow it", location, prior),
+			)
+			continue
+		}
+
+		seen[location] = i
+	}
+}
+
+// validatelinks reports hardlinks whose target is not provided by an earlier
+// source and, when strict, symlinks escaping the archive root. hardlinks are
+// only checked when every earlier location is known and the archive preserves
+// the order of the sources, otherwise the check is deferred until apply.
+func validatelinks(data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	var (
+		known = !data.Reproducible.IsUnknown() && !data.Reproducible.ValueBool()
+		seen  = map[string]bool{}
+	)
+
+	for i, v := range data.Sources {
+		p := path.Root("source").AtListIndex(i)
+		if v.Location.IsUnknown() || v.expands() {
+			known = false
+		}
+
+		if v.Location.IsNull() || v.Location.IsUnknown() {
+			continue
+		}
+
+		location := v.location()
+		if !v.Symlink.IsNull() && !v.Symlink.IsUnknown() && data.StrictLinks.ValueBool() && escapes(location, v.Symlink.ValueString()) {
+			diags.AddAttributeError(
+				p.AtName("symlink"),
+				"symlink escapes the archive",
+				fmt.Sprintf("%s -> %s resolves outside of the archive root, disable strict_links to allow it", location, v.Symlink.ValueString()),
+			)
+		}
+
+		if !v.Hardlink.IsNull() && !v.Hardlink.IsUnknown() && known && !seen[normalizelocation(v.Hardlink.ValueString())] {
+			diags.AddAttributeError(
+				p.AtName("hardlink"),
+				"hardlink target missing",
+				fmt.Sprintf("%s references %s which is not provided by an earlier source", location, v.Hardlink.ValueString()),
+			)
+		}
+
+		seen[location] = true
+	}
+}
+
+// validateformat reports sources producing entries the format does not support.
+func validateformat(f *format, data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	for i, v := range data.Sources {
+		if !v.Method.IsNull() && !f.methods {
+			diags.AddAttributeError(
+				path.Root("source").AtListIndex(i).AtName("method"),
+				"unsupported method",
+				fmt.Sprintf("%s archives are compressed as a whole, only zip archives compress each file", f.name),
+			)
+		}
+
+		kind := v.kind()
+		if !slices.Contains(f.unsupported, kind) {
+			continue
+		}
+
+		diags.AddAttributeError(
+			path.Root("source").AtListIndex(i).AtName(kind),
+			"unsupported source",
+			fmt.Sprintf("%s archives do not support %s sources", f.name, kind),
+		)
+	}
+}
+
+// validatetemplates reports templates that fail to parse, or when every var is
+// known, fail to render.
+func validatetemplates(ctx context.Context, data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	for i, v := range data.Sources {
+		if v.Template.IsNull() || v.Template.IsUnknown() || v.Location.IsUnknown() {
+			continue
+		}
+
+		_, err := parsetemplate(v.location(), v.Template.ValueString())
+		if err == nil && known(v.Vars) {
+			_, err = v.render(ctx)
+		}
+
+		if err != nil {
+			diags.Append(errdiag("invalid template", attributed(path.Root("source").AtListIndex(i).AtName("template"), err)))
+		}
+	}
+}
+
 func (r *ArchiveResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	// Prevent panic if the provider has not been configured.
 	if req.ProviderData == nil {
 		return
 	}
+
+	pd, ok := req.ProviderData.(*providerdata)
+	if !ok {
+		resp.Diagnostics.AddError("unexpected provider data", fmt.Sprintf("expected *providerdata, got %T", req.ProviderData))
+		return
+	}
+
+	r.provider = pd
+}
+
+// ModifyPlan plans the attributes derived from the content of the sources.
+func (r *ArchiveResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	var (
+		data  ArchiveResourceModel
+		prior *ArchiveResourceModel
+	)
+
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.plan(ctx, &data, prior, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
+}
+
+// plan the digests of the sources, the manifest and the timestamp of the
+// archive. the sources are resolved and their content hashed once, the limits
+// of the archive are enforced against the resolved entries before any of them
+// are hashed. prior is nil when the archive is created.
+func (r *ArchiveResource) plan(ctx context.Context, data, prior *ArchiveResourceModel, diags *diag.Diagnostics) {
+	content, err := digest(r.limited(ctx, data), data)
+	if err != nil {
+		diags.Append(errdiag("failed to plan archive", err))
+		return
+	}
+
+	for _, v := range data.Sources {
+		v.Digest = content.digestof(v, data.DigestAlgorithm)
+		v.Digests = content.digestsof(v)
+	}
+
+	data.Manifest = types.ListUnknown(manifestType)
+	if content.manifest != nil {
+		if data.Manifest, err = manifestvalue(content.manifest); err != nil {
+			diags.Append(errdiag("failed to compute manifest", err))
+			return
+		}
+	}
+
+	if data.Timestamp, err = plantimestamp(ctx, data, prior, content); err != nil {
+		diags.Append(errdiag("failed to plan timestamp", err))
+	}
 }
 
 func (r *ArchiveResource) generate(ctx context.Context, ts time.Time, dst *os.File, data *ArchiveResourceModel) error {
 	var (
-		digest = sha256.New()
+		algorithm = data.DigestAlgorithm.ValueString()
+		digest    = newhash(algorithm)
+		adigest   = newhash(algorithm)
+		counter   = &iox.Counter{}
+		encoded   = strings.Builder{}
+		b64       io.WriteCloser
+		archived  io.Writer = io.MultiWriter(dst, adigest, counter)
 	)
 
-	b64 := base64.NewEncoder(base64.StdEncoding, dst)
-	gw := gzip.NewWriter(b64)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	f := r.archiveformat()
+	codec, level := data.codec(f)
+
+	if data.StoreArchiveB64.IsNull() || data.StoreArchiveB64.ValueBool() {
+		b64 = base64.NewEncoder(base64.StdEncoding, &encoded)
+		archived = io.MultiWriter(archived, b64)
+	} else {
+		b64 = iox.WriteNopCloser(io.Discard)
+	}
 
+	aw, err := f.writer(archived, codec, level)
+	if err != nil {
+		return attributed(path.Root("compression_level"), errorsx.UserFriendly(err))
+	}
+	defer aw.Close()
+
+	members, err := resolve(ctx, ts, data)
+	if err != nil {
+		return err
+	}
+	defer closemembers(members...)
+
+	digests := make(map[*SourceModel]map[string]string, len(data.Sources))
+	hashes := make(map[*SourceModel]hash.Hash, len(data.Sources))
 	for _, v := range data.Sources {
+		digests[v] = map[string]string{}
+		hashes[v] = newhash(algorithm)
+	}
+
+	// file sources digest their content directly, directories and archives digest their listing.
+	sourcehash := func(e member) io.Writer {
+		if e.source.expands() {
+			return io.Discard
+		}
+
+		return hashes[e.source]
+	}
+
+	manifest := make([]ManifestModel, 0, len(members))
+	for _, e := range members {
+		// synthesized parent directories have no source.
+		if e.source == nil {
+			if err = conform(f, e.header, ""); err != nil {
+				return attributed(e.path, errorsx.UserFriendly(err))
+			}
+
+			if err = aw.WriteHeader(e.header); err != nil {
+				return attributed(e.path, errorsx.Wrapf(err, "failed to write header for %s", e.header.Name))
+			}
+
+			manifest = append(manifest, manifested(e.header, ""))
+			continue
+		}
+
 		localdigest := sha256.New()
-		bstr := v.Base64.ValueString()
 
-		decoded, err := base64.StdEncoding.DecodeString(bstr)
-		if err != nil {
-			return err
+		// dropped entries still contribute to the digests of their source.
+		if e.dropped {
+			if err = e.hash(io.MultiWriter(localdigest, sourcehash(e))); err != nil {
+				return attributed(e.path.AtName(e.source.kind()), err)
+			}
+
+			digests[e.source][e.header.Name] = hex.EncodeToString(localdigest.Sum(nil))
+			continue
 		}
-		mode := int32(0600)
-		if !v.Perm.IsNull() {
-			mode = v.Perm.ValueInt32()
+
+		if err = conform(f, e.header, e.source.kind()); err != nil {
+			return attributed(e.path.AtName(e.source.kind()), errorsx.UserFriendly(err))
+		}
+
+		if e.open == nil {
+			if err = aw.WriteHeader(e.header); err != nil {
+				return attributed(e.path.AtName(e.source.kind()), errorsx.UserFriendly(errorsx.Wrapf(err, "failed to write header for %s", e.header.Name)))
+			}
+
+			if err = e.hash(io.MultiWriter(localdigest, sourcehash(e))); err != nil {
+				return attributed(e.path, err)
+			}
+
+			digests[e.source][e.header.Name] = hex.EncodeToString(localdigest.Sum(nil))
+			manifest = append(manifest, manifested(e.header, digests[e.source][e.header.Name]))
+			continue
 		}
 
-		err = tarx.WriteFileToArchive(tw, tarx.NewHeader(v.Location.ValueString(), ts, int64(len(decoded)), int64(mode)), io.TeeReader(bytes.NewReader(decoded), io.MultiWriter(digest, localdigest)))
+		src, err := e.open()
 		if err != nil {
-			return err
+			return attributed(e.path.AtName(e.source.kind()), errorsx.UserFriendly(errorsx.Wrapf(err, "unable to read content for %s", e.header.Name)))
+		}
+
+		tee := io.TeeReader(src, io.MultiWriter(digest, localdigest, sourcehash(e)))
+		if mw, ok := aw.(methodical); ok && !e.source.Method.IsNull() {
+			err = mw.WriteFileMethod(e.header, zipx.Method(e.source.Method.ValueString()), tee)
+		} else {
+			err = aw.WriteFile(e.header, tee)
+		}
+		if err = errorsx.Compact(err, src.Close()); err != nil {
+			return attributed(e.path, errorsx.Wrapf(err, "failed to archive %s", e.header.Name))
 		}
 
-		v.Digest = basetypes.NewStringValue(hex.EncodeToString(localdigest.Sum(nil)))
+		digests[e.source][e.header.Name] = hex.EncodeToString(localdigest.Sum(nil))
+		manifest = append(manifest, manifested(e.header, digests[e.source][e.header.Name]))
 	}
 
-	data.Digest = basetypes.NewStringValue(hex.EncodeToString(digest.Sum(nil)))
-	if err := errorsx.Compact(tw.Close(), gw.Close(), b64.Close()); err != nil {
+	if data.Manifest, err = manifestvalue(manifest); err != nil {
 		return err
 	}
 
-	encodedstr, err := iox.String(dst)
-	if err != nil {
+	for _, v := range data.Sources {
+		v.Digests = digestsvalue(digests[v])
+		v.Digest = basetypes.NewStringValue(sourcedigest(v, digests[v], hashes[v]))
+	}
+
+	data.ContentDigest = basetypes.NewStringValue(hex.EncodeToString(digest.Sum(nil)))
+	data.Digest = data.ContentDigest
+	data.Mimetype = basetypes.NewStringValue(f.mimetype(codec))
+	if err := errorsx.Compact(aw.Close(), b64.Close()); err != nil {
 		return err
 	}
-	// tflog.Info(ctx, fmt.Sprintf("debug encoded archive %s", encodedstr))
-	data.ArchiveB64 = basetypes.NewStringValue(encodedstr)
+
+	data.ArchiveDigest = basetypes.NewStringValue(hex.EncodeToString(adigest.Sum(nil)))
+	data.ArchiveSize = basetypes.NewInt64Value(counter.N)
+	// tflog.Info(ctx, fmt.Sprintf("debug encoded archive %s", encoded.String()))
+	if data.StoreArchiveB64.IsNull() || data.StoreArchiveB64.ValueBool() {
+		data.ArchiveB64 = basetypes.NewStringValue(encoded.String())
+	} else {
+		data.ArchiveB64 = basetypes.NewStringNull()
+	}
 
 	return nil
 }
 
-func (r *ArchiveResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
-	var data ArchiveResourceModel
+// member of the archive along with the source that produced it.
+type member struct {
+	entry
+	// source of the entry, nil for synthesized parent directories.
+	source  *SourceModel
+	path    path.Path
+	dropped bool
+}
 
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+// resolve the members of the archive in the order they are written. duplicates
+// dropped by the policy are retained, flagged as dropped, so they still
+// contribute to the digests of their source.
+func resolve(ctx context.Context, ts time.Time, data *ArchiveResourceModel) (resolved []member, err error) {
+	members := make([]member, 0, len(data.Sources))
+	for i, v := range data.Sources {
+		p := path.Root("source").AtListIndex(i)
+		entries, err := v.entries(ctx, ts)
+		if err != nil {
+			closemembers(members...)
+			return nil, attributed(p.AtName(v.kind()), err)
+		}
 
-	if resp.Diagnostics.HasError() {
-		return
+		members = append(members, sourced(v, p, entries...)...)
 	}
 
-	ts := time.Now()
-	data.Timestamp = basetypes.NewInt64Value(ts.UnixMilli())
+	return arrange(ctx, ts, data, members)
+}
+
+// sourced returns the members for the entries of the source at the path.
+func sourced(v *SourceModel, p path.Path, entries ...entry) []member {
+	members := make([]member, 0, len(entries))
+	for _, e := range entries {
+		members = append(members, member{entry: e, source: v, path: p})
+	}
 
-	dst, err := os.CreateTemp("", "egt.archive.*")
+	return members
+}
+
+// arrange the members of the sources in the order they are written, see
+// resolve. the members are released when they can not be arranged.
+func arrange(ctx context.Context, ts time.Time, data *ArchiveResourceModel, members []member) (resolved []member, err error) {
+	defer func() {
+		if err != nil {
+			closemembers(members...)
+		}
+	}()
+
+	// entries of other sources take precedence over those copied from archives.
+	explicit := make(map[string]bool, len(members))
+	for _, e := range members {
+		if !e.source.archived() {
+			explicit[strings.TrimSuffix(e.header.Name, "/")] = true
+		}
+	}
+
+	dups := newduplicates(data.OnDuplicate.ValueString())
+	for i, e := range members {
+		if e.source.archived() && explicit[strings.TrimSuffix(e.header.Name, "/")] {
+			members[i].dropped = true
+			continue
+		}
+
+		drop, err := dups.observe(strings.TrimSuffix(e.header.Name, "/"), i)
+		if err != nil {
+			return nil, attributed(e.path.AtName(e.source.locationattr()), err)
+		}
+
+		if drop >= 0 {
+			members[drop].dropped = true
+		}
+	}
+
+	if data.Reproducible.ValueBool() {
+		// hardlinks follow every other entry so their targets precede them.
+		sort.SliceStable(members, func(i, j int) bool {
+			ilink, jlink := members[i].header.Typeflag == tar.TypeLink, members[j].header.Typeflag == tar.TypeLink
+			if ilink != jlink {
+				return jlink
+			}
+
+			return members[i].header.Name < members[j].header.Name
+		})
+
+		// ownership is only retained when explicitly set on the source.
+		for _, e := range members {
+			e.source.owner(tarx.NormalizeOwner(tarx.Normalize(e.header)))
+		}
+	}
+
+	parents, err := newparentdirs(ctx, ts, data)
 	if err != nil {
-		panic(err)
+		return nil, attributed(path.Root("directory_perms"), err)
+	}
+
+	written := make(map[string]bool, len(members))
+	resolved = make([]member, 0, len(members))
+	for _, e := range members {
+		if e.dropped {
+			resolved = append(resolved, e)
+			continue
+		}
+
+		if err = checklink(e.header, written, data.StrictLinks.ValueBool()); err != nil {
+			return nil, attributed(e.path.AtName(e.source.kind()), err)
+		}
+		written[e.header.Name] = true
+
+		for _, hdr := range parents.missing(e.header) {
+			resolved = append(resolved, member{entry: entry{header: hdr}, path: e.path})
+		}
+
+		resolved = append(resolved, e)
 	}
+
+	if err = limitsfrom(ctx).check(resolved...); err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+func closemembers(members ...member) {
+	for _, e := range members {
+		closeentries(e.entry)
+	}
+}
+
+// parentdirs synthesizes directory entries for the parents of archive members.
+type parentdirs struct {
+	enabled      bool
+	reproducible bool
+	ts           time.Time
+	mode         int64
+	overrides    map[string]int64
+	emitted      map[string]bool
+}
+
+func newparentdirs(ctx context.Context, ts time.Time, data *ArchiveResourceModel) (_ *parentdirs, err error) {
+	var (
+		overrides map[string]int32
+	)
+
+	pd := &parentdirs{
+		enabled:      data.ParentDirs.ValueBool(),
+		reproducible: data.Reproducible.ValueBool(),
+		ts:           ts,
+		mode:         0755,
+		overrides:    map[string]int64{},
+		emitted:      map[string]bool{},
+	}
+
+	if !data.DirPerm.IsNull() && !data.DirPerm.IsUnknown() {
+		pd.mode = int64(data.DirPerm.ValueInt32())
+	}
+
+	if !data.DirPerms.IsNull() && !data.DirPerms.IsUnknown() {
+		if diags := data.DirPerms.ElementsAs(ctx, &overrides, false); diags.HasError() {
+			return nil, errorsx.Errorf("unable to decode directory permissions: %v", diags)
+		}
+	}
+
+	for dir, mode := range overrides {
+		pd.overrides[strings.Trim(pathpkg.Clean(dir), "/")] = int64(mode)
+	}
+
+	return pd, nil
+}
+
+// missing returns the headers of the parent directories of the entry that have
+// not been emitted yet, ordered from the root.
+func (t *parentdirs) missing(hdr *tar.Header) (dirs []*tar.Header) {
+	if hdr.Typeflag == tar.TypeDir {
+		t.emitted[strings.Trim(pathpkg.Clean(hdr.Name), "/")] = true
+	}
+
+	if !t.enabled {
+		return nil
+	}
+
+	for _, dir := range tarx.Parents(hdr.Name) {
+		if t.emitted[dir] {
+			continue
+		}
+		t.emitted[dir] = true
+
+		mode, ok := t.overrides[dir]
+		if !ok {
+			mode = t.mode
+		}
+
+		dhdr := tarx.NewDirHeader(dir, t.ts, mode)
+		if t.reproducible {
+			tarx.Normalize(dhdr)
+		}
+
+		dirs = append(dirs, dhdr)
+	}
+
+	return dirs
+}
+
+// build the archive, when output is enabled the archive is atomically written to the output path.
+func (r *ArchiveResource) build(ctx context.Context, ts time.Time, data *ArchiveResourceModel, output bool) (err error) {
+	var (
+		dir = ""
+	)
+
+	output = output && !data.OutputPath.IsNull()
+	if output {
+		dir = filepath.Dir(data.OutputPath.ValueString())
+		if err = os.MkdirAll(dir, fs.FileMode(data.OutputDirPerm.ValueInt32())); err != nil {
+			return attributed(path.Root("output_path"), errorsx.Wrapf(err, "failed to create output directory %s", dir))
+		}
+	}
+
+	dst, err := os.CreateTemp(dir, ".egt.archive.*")
+	if err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrap(err, "failed to create temporary archive"))
+	}
+	defer os.Remove(dst.Name())
 	defer dst.Close()
 
-	if err = r.generate(ctx, ts, dst, &data); err != nil {
-		panic(err)
+	if err = r.generate(ctx, ts, dst, data); err != nil {
+		return err
+	}
+
+	if !output {
+		return nil
+	}
+
+	if err = errorsx.Compact(dst.Chmod(fs.FileMode(data.OutputFilePerm.ValueInt32())), dst.Sync(), dst.Close()); err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrap(err, "failed to finalize archive"))
+	}
+
+	return attributed(path.Root("output_path"), errorsx.Wrapf(os.Rename(dst.Name(), data.OutputPath.ValueString()), "failed to write archive to %s", data.OutputPath.ValueString()))
+}
+
+// drifted reports if the archive recorded in state no longer matches its
+// digest, either the file written to the output path was removed or modified
+// or the stored archiveb64 does not match. the archive itself is never regenerated.
+func (r *ArchiveResource) drifted(data *ArchiveResourceModel) (string, error) {
+	if data.ArchiveDigest.IsNull() {
+		return "", nil
+	}
+
+	if !data.ArchiveB64.IsNull() {
+		digest := newhash(data.DigestAlgorithm.ValueString())
+		decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(data.ArchiveB64.ValueString()))
+		if _, err := io.Copy(digest, decoder); err != nil {
+			return "archiveb64 is not valid base64", nil
+		}
+
+		if hex.EncodeToString(digest.Sum(nil)) != data.ArchiveDigest.ValueString() {
+			return "archiveb64 does not match the archive digest", nil
+		}
+	}
+
+	if data.OutputPath.IsNull() {
+		return "", nil
+	}
+
+	current, err := iox.Digest(data.OutputPath.ValueString(), newhash(data.DigestAlgorithm.ValueString()))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Sprintf("archive %s is missing", data.OutputPath.ValueString()), nil
+	} else if err != nil {
+		return "", attributed(path.Root("output_path"), err)
+	}
+
+	if current != data.ArchiveDigest.ValueString() {
+		return fmt.Sprintf("archive %s was modified", data.OutputPath.ValueString()), nil
+	}
+
+	return "", nil
+}
+
+func (r *ArchiveResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var (
+		data ArchiveResourceModel
+	)
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.create(ctx, &data, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
 	}
 
 	// Save data into Terraform state
@@ -195,43 +1206,39 @@ func (r *ArchiveResource) Read(ctx context.Context, req resource.ReadRequest, re
 		return
 	}
 
-	ts := time.UnixMilli(data.Timestamp.ValueInt64())
-	dst, err := os.CreateTemp("", "egt.archive.*")
-	if err != nil {
-		panic(err)
+	if !r.exists(ctx, &data, &resp.Diagnostics) {
+		resp.State.RemoveResource(ctx)
 	}
-	defer dst.Close()
-
-	if err = r.generate(ctx, ts, dst, &data); err != nil {
-		panic(err)
-	}
-
-	// Save updated data into Terraform state
-	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
 func (r *ArchiveResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var (
-		data ArchiveResourceModel
+		data  ArchiveResourceModel
+		prior ArchiveResourceModel
 	)
 
 	// Read Terraform plan data into the model
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
 
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	ts := time.UnixMilli(data.Timestamp.ValueInt64())
-	dst, err := os.CreateTemp("", "egt.archive.*")
-	if err != nil {
-		panic(err)
+	imported, diags := adopted(ctx, req.Private)
+	if resp.Diagnostics.Append(diags...); resp.Diagnostics.HasError() {
+		return
+	}
+
+	// the archive adopted by an import is never removed.
+	if imported != "" && prior.OutputPath.ValueString() == imported {
+		prior.OutputPath = types.StringNull()
 	}
-	defer dst.Close()
 
-	if err = r.generate(ctx, ts, dst, &data); err != nil {
-		panic(err)
+	if r.update(ctx, &data, &prior, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
 	}
+
 	// Save updated data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
@@ -245,8 +1252,111 @@ func (r *ArchiveResource) Delete(ctx context.Context, req resource.DeleteRequest
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	imported, diags := adopted(ctx, req.Private)
+	if resp.Diagnostics.Append(diags...); resp.Diagnostics.HasError() {
+		return
+	}
+
+	// the archive adopted by an import is never removed.
+	if imported != "" && data.OutputPath.ValueString() == imported {
+		return
+	}
+
+	r.delete(&data, &resp.Diagnostics)
+}
+
+// create generates the planned archive.
+func (r *ArchiveResource) create(ctx context.Context, data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	ts, err := data.timestamp()
+	if err != nil {
+		diags.Append(errdiag("invalid source date epoch", attributed(path.Root("source_date_epoch"), err)))
+		return
+	}
+	data.Timestamp = basetypes.NewInt64Value(ts.UnixMilli())
+
+	if err = r.build(r.limited(ctx, data), ts, data, true); err != nil {
+		diags.Append(errdiag("failed to generate archive", err))
+		return
+	}
 }
 
+// update regenerates the archive removing the previous output when it moved.
+func (r *ArchiveResource) update(ctx context.Context, data, prior *ArchiveResourceModel, diags *diag.Diagnostics) {
+	if r.create(ctx, data, diags); diags.HasError() {
+		return
+	}
+
+	if !prior.OutputPath.IsNull() && prior.OutputPath.ValueString() != data.OutputPath.ValueString() {
+		errorsx.Log(errorsx.Wrap(remove(prior.OutputPath.ValueString()), "failed to remove previous archive"))
+	}
+}
+
+// exists reports if the archive recorded in state is intact, drifted archives are recreated.
+func (r *ArchiveResource) exists(ctx context.Context, data *ArchiveResourceModel, diags *diag.Diagnostics) bool {
+	reason, err := r.drifted(data)
+	if err != nil {
+		diags.Append(errdiag("failed to verify archive", err))
+		return true
+	}
+
+	if reason != "" {
+		tflog.Info(ctx, fmt.Sprintf("%s, recreating", reason))
+		return false
+	}
+
+	return true
+}
+
+// delete the archive written to the output path.
+func (r *ArchiveResource) delete(data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	if data.OutputPath.IsNull() {
+		return
+	}
+
+	if err := remove(data.OutputPath.ValueString()); err != nil {
+		diags.AddAttributeError(path.Root("output_path"), "failed to remove archive", err.Error())
+	}
+}
+
+// ImportState adopts an existing archive, the import id is the path to the
+// archive. the adopted archive is recorded in private state so it is never
+// removed when the output path changes or the resource is destroyed.
 func (r *ArchiveResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+	if f := r.archiveformat(); f != formattar {
+		resp.Diagnostics.AddError("import not supported", fmt.Sprintf("%s archives can not be imported", f.name))
+		return
+	}
+
+	data, diags := r.adopt(ctx, req.ID)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	encoded, err := json.Marshal(data.OutputPath.ValueString())
+	if err != nil {
+		resp.Diagnostics.Append(errdiag("unable to record adopted archive", err))
+		return
+	}
+
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, adoptedkey, encoded)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func codecnames(codecs ...tarx.Codec) (names []string) {
+	for _, c := range codecs {
+		names = append(names, string(c))
+	}
+
+	return names
+}
+
+// remove the file ignoring it already being absent.
+func remove(p string) error {
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
 }
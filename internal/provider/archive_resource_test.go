@@ -0,0 +1,911 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+type testentry struct {
+	header   *tar.Header
+	contents []byte
+}
+
+func readarchiveb64(t *testing.T, encoded string) (entries []testentry) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+
+	cr, _, err := tarx.Decompress(bytes.NewReader(decoded))
+	require.NoError(t, err)
+	defer cr.Close()
+
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries = append(entries, testentry{header: hdr, contents: contents})
+	}
+}
+
+// plannedmodel returns the model of a planned resource with every attribute
+// set to its default and computed attributes unknown.
+func plannedmodel(sources ...*SourceModel) ArchiveResourceModel {
+	return ArchiveResourceModel{
+		Reproducible:     types.BoolValue(false),
+		SourceDateEpoch:  types.Int64Null(),
+		TimestampPolicy:  types.StringValue(timestampOnChange),
+		Compression:      types.StringValue(string(tarx.CodecGzip)),
+		CompressionLevel: types.Int64Null(),
+		OnDuplicate:      types.StringValue(duplicateError),
+		StrictLinks:      types.BoolValue(false),
+		ParentDirs:       types.BoolValue(false),
+		DirPerm:          types.Int32Value(0755),
+		DirPerms:         types.MapNull(types.Int32Type),
+		DigestAlgorithm:  types.StringValue(digestSHA256),
+		OutputPath:       types.StringNull(),
+		OutputFilePerm:   types.Int32Value(0600),
+		OutputDirPerm:    types.Int32Value(0750),
+		StoreArchiveB64:  types.BoolValue(true),
+		Timestamp:        types.Int64Unknown(),
+		Digest:           types.StringUnknown(),
+		ContentDigest:    types.StringUnknown(),
+		Mimetype:         types.StringUnknown(),
+		ArchiveDigest:    types.StringUnknown(),
+		ArchiveSize:      types.Int64Unknown(),
+		ArchiveB64:       types.StringUnknown(),
+		Manifest:         types.ListUnknown(manifestType),
+		Sources:          sources,
+	}
+}
+
+// modifyplan runs ModifyPlan for the planned model and the prior state, nil
+// when the archive is created, returning the modified plan.
+func modifyplan(t *testing.T, r *ArchiveResource, data ArchiveResourceModel, prior *ArchiveResourceModel) (planned ArchiveResourceModel, _ diag.Diagnostics) {
+	ctx := context.Background()
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	plan := tfsdk.Plan{Schema: schemaresp.Schema}
+	require.False(t, plan.Set(ctx, &data).HasError())
+	state := tfsdk.State{Schema: schemaresp.Schema}
+	if prior != nil {
+		require.False(t, state.Set(ctx, prior).HasError())
+	}
+
+	resp := &resource.ModifyPlanResponse{Plan: plan}
+	r.ModifyPlan(ctx, resource.ModifyPlanRequest{Plan: plan, State: state}, resp)
+	if resp.Diagnostics.HasError() {
+		return planned, resp.Diagnostics
+	}
+
+	require.False(t, resp.Plan.Get(ctx, &planned).HasError())
+	return planned, resp.Diagnostics
+}
+
+func TestArchiveResourceGenerateMetadata(t *testing.T) {
+	ts := time.UnixMilli(1700000000000)
+	data := ArchiveResourceModel{
+		Sources: []*SourceModel{
+			{
+				Base64:   types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n"))),
+				Location: types.StringValue("usr/bin/agent"),
+				Perm:     types.Int32Value(0755),
+				Uid:      types.Int64Value(0),
+				Gid:      types.Int64Value(0),
+				Uname:    types.StringValue("root"),
+				Gname:    types.StringValue("root"),
+				Mtime:    types.Int64Value(1600000000000),
+			},
+			{
+				Base64:   types.StringValue(base64.StdEncoding.EncodeToString([]byte("key: value\n"))),
+				Location: types.StringValue("etc/agent.yaml"),
+				Perm:     types.Int32Null(),
+				Uid:      types.Int64Null(),
+				Gid:      types.Int64Null(),
+				Uname:    types.StringNull(),
+				Gname:    types.StringNull(),
+				Mtime:    types.Int64Null(),
+			},
+		},
+	}
+
+	dst, err := os.CreateTemp(t.TempDir(), "egt.archive.*")
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, (&ArchiveResource{}).generate(context.Background(), ts, dst, &data))
+
+	entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "usr/bin/agent", entries[0].header.Name)
+	require.Equal(t, int64(0755), entries[0].header.Mode)
+	require.Equal(t, 0, entries[0].header.Uid)
+	require.Equal(t, 0, entries[0].header.Gid)
+	require.Equal(t, "root", entries[0].header.Uname)
+	require.Equal(t, "root", entries[0].header.Gname)
+	require.True(t, time.UnixMilli(1600000000000).Equal(entries[0].header.ModTime))
+	require.Equal(t, "#!/bin/sh\n", string(entries[0].contents))
+
+	require.Equal(t, "etc/agent.yaml", entries[1].header.Name)
+	require.Equal(t, int64(0600), entries[1].header.Mode)
+	require.True(t, ts.Truncate(time.Second).Equal(entries[1].header.ModTime.Truncate(time.Second)))
+	require.Equal(t, "key: value\n", string(entries[1].contents))
+}
+
+func TestArchiveResourceGeneratePath(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "example.txt")
+	require.NoError(t, os.WriteFile(src, []byte("hello world"), 0600))
+
+	data := ArchiveResourceModel{
+		Sources: []*SourceModel{
+			{
+				Path:     types.StringValue(src),
+				Location: types.StringValue("example.txt"),
+			},
+		},
+	}
+
+	dst, err := os.CreateTemp(dir, "egt.archive.*")
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, (&ArchiveResource{}).generate(context.Background(), time.Now(), dst, &data))
+
+	entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+	require.Len(t, entries, 1)
+	require.Equal(t, "example.txt", entries[0].header.Name)
+	require.Equal(t, "hello world", string(entries[0].contents))
+	require.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", data.Sources[0].Digest.ValueString())
+}
+
+func TestArchiveResourceGenerateDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "app"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app", "config.yaml"), []byte("config"), 0640))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app", "debug.log"), []byte("log"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("*.log\n"), 0600))
+
+	data := ArchiveResourceModel{
+		Sources: []*SourceModel{
+			{
+				Directory:   types.StringValue(dir),
+				Exclude:     types.ListValueMust(types.StringType, []attr.Value{types.StringValue(".dockerignore")}),
+				IgnoreFiles: types.ListValueMust(types.StringType, []attr.Value{types.StringValue(".dockerignore")}),
+				Prefix:      types.StringValue("etc"),
+			},
+		},
+	}
+
+	dst, err := os.CreateTemp(t.TempDir(), "egt.archive.*")
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, (&ArchiveResource{}).generate(context.Background(), time.Now(), dst, &data))
+
+	entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+	require.Len(t, entries, 1)
+	require.Equal(t, "etc/app/config.yaml", entries[0].header.Name)
+	require.Equal(t, int64(0640), entries[0].header.Mode)
+
+	content, err := digest(context.Background(), &data)
+	require.NoError(t, err)
+	digests := content.digests[data.Sources[0]]
+	require.Equal(t, digests, map[string]string{
+		"etc/app/config.yaml": "b79606fb3afea5bd1609ed40b622142f1c98125abcfe89a76a661b0e8e343910",
+	})
+	require.Equal(t, sourcedigest(data.Sources[0], digests, sha256.New()), data.Sources[0].Digest.ValueString())
+	require.Equal(t, content.sources[data.Sources[0]], data.Sources[0].Digest.ValueString())
+}
+
+func TestArchiveResourceGenerateCompression(t *testing.T) {
+	for _, codec := range tarx.WritableCodecs() {
+		t.Run(string(codec), func(t *testing.T) {
+			data := ArchiveResourceModel{
+				Compression:      types.StringValue(string(codec)),
+				CompressionLevel: types.Int64Null(),
+				Sources: []*SourceModel{
+					{
+						Base64:   types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world"))),
+						Location: types.StringValue("example.txt"),
+					},
+				},
+			}
+
+			dst, err := os.CreateTemp(t.TempDir(), "egt.archive.*")
+			require.NoError(t, err)
+			defer dst.Close()
+
+			require.NoError(t, (&ArchiveResource{}).generate(context.Background(), time.Now(), dst, &data))
+			require.Equal(t, codec.Mimetype(), data.Mimetype.ValueString())
+
+			decoded, err := base64.StdEncoding.DecodeString(data.ArchiveB64.ValueString())
+			require.NoError(t, err)
+			cr, detected, err := tarx.Decompress(bytes.NewReader(decoded))
+			require.NoError(t, err)
+			require.NoError(t, cr.Close())
+			require.Equal(t, codec, detected)
+
+			entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+			require.Len(t, entries, 1)
+			require.Equal(t, "hello world", string(entries[0].contents))
+		})
+	}
+}
+
+func TestArchiveResourceBuildOutputPath(t *testing.T) {
+	r := &ArchiveResource{}
+	output := filepath.Join(t.TempDir(), "nested", "example.tar.gz")
+	data := ArchiveResourceModel{
+		OutputPath:      types.StringValue(output),
+		OutputFilePerm:  types.Int32Value(0640),
+		OutputDirPerm:   types.Int32Value(0750),
+		StoreArchiveB64: types.BoolValue(false),
+		Sources: []*SourceModel{
+			{
+				Base64:   types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world"))),
+				Location: types.StringValue("example.txt"),
+			},
+		},
+	}
+
+	require.NoError(t, r.build(context.Background(), time.Now(), &data, true))
+	require.True(t, data.ArchiveB64.IsNull())
+
+	info, err := os.Stat(output)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	require.Equal(t, info.Size(), data.ArchiveSize.ValueInt64())
+
+	digest, err := iox.Sha256(output)
+	require.NoError(t, err)
+	require.Equal(t, digest, data.ArchiveDigest.ValueString())
+
+	reason, err := r.drifted(&data)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+
+	require.NoError(t, os.WriteFile(output, []byte("modified"), 0640))
+	reason, err = r.drifted(&data)
+	require.NoError(t, err)
+	require.Contains(t, reason, "modified")
+
+	require.NoError(t, os.Remove(output))
+	reason, err = r.drifted(&data)
+	require.NoError(t, err)
+	require.Contains(t, reason, "missing")
+
+	remaining, err := filepath.Glob(filepath.Join(filepath.Dir(output), ".egt.archive.*"))
+	require.NoError(t, err)
+	require.Empty(t, remaining)
+}
+
+func TestArchiveResourceGenerateReproducible(t *testing.T) {
+	build := func(codec tarx.Codec, reversed bool) ArchiveResourceModel {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("config"), 0640))
+
+		// the upstream archive carries the owner of the machine it was built on.
+		upstream := bytes.Buffer{}
+		tw := tar.NewWriter(&upstream)
+		require.NoError(t, tarx.WriteFileToArchive(tw, tarx.NewHeader("lib/upstream.so", time.Unix(1600000000, 0), 4, 0644, tarx.HeaderOptionOwner(1000, 100), tarx.HeaderOptionOwnerNames("builder", "staff")), bytes.NewReader([]byte("elf\n"))))
+		require.NoError(t, tw.Close())
+
+		sources := []*SourceModel{
+			{
+				ArchiveB64: types.StringValue(base64.StdEncoding.EncodeToString(upstream.Bytes())),
+				Prefix:     types.StringValue("usr"),
+				Gname:      types.StringValue("agent"),
+			},
+			{
+				Base64:   types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n"))),
+				Location: types.StringValue("usr/bin/agent"),
+				Perm:     types.Int32Value(0755),
+			},
+			{
+				Directory: types.StringValue(dir),
+				Prefix:    types.StringValue("etc/agent"),
+			},
+		}
+
+		if reversed {
+			slices.Reverse(sources)
+		}
+
+		data := ArchiveResourceModel{
+			Compression:     types.StringValue(string(codec)),
+			Reproducible:    types.BoolValue(true),
+			SourceDateEpoch: types.Int64Value(1700000000),
+			Sources:         sources,
+		}
+
+		ts, err := sourcedateepoch(data.SourceDateEpoch)
+		require.NoError(t, err)
+		require.NoError(t, (&ArchiveResource{}).build(context.Background(), ts, &data, false))
+		return data
+	}
+
+	for codec, expected := range map[tarx.Codec]string{
+		tarx.CodecNone: "6d28170438cae24e8606c57e14ccca0df9e985e161aff7f6a7a48f374a58f471",
+		tarx.CodecGzip: "9ee58b0787ecdb0c3c95733e5ad3d546e31b8b22ff49459a5ff5f25bf78add5b",
+	} {
+		t.Run(string(codec), func(t *testing.T) {
+			a := build(codec, false)
+			time.Sleep(10 * time.Millisecond)
+			b := build(codec, true)
+
+			require.Equal(t, a.ArchiveB64.ValueString(), b.ArchiveB64.ValueString())
+			require.Equal(t, expected, a.ArchiveDigest.ValueString())
+
+			entries := readarchiveb64(t, a.ArchiveB64.ValueString())
+			require.Len(t, entries, 3)
+			require.Equal(t, "etc/agent/config.yaml", entries[0].header.Name)
+			require.Equal(t, "usr/bin/agent", entries[1].header.Name)
+			require.True(t, time.Unix(1700000000, 0).Equal(entries[1].header.ModTime))
+			require.Equal(t, "usr/lib/upstream.so", entries[2].header.Name)
+			require.Equal(t, 0, entries[2].header.Uid)
+			require.Equal(t, 0, entries[2].header.Gid)
+			require.Equal(t, "", entries[2].header.Uname)
+			require.Equal(t, "agent", entries[2].header.Gname)
+		})
+	}
+}
+
+func TestArchiveResourceGenerateDiagnostics(t *testing.T) {
+	data := ArchiveResourceModel{
+		Sources: []*SourceModel{
+			{
+				Base64:   types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world"))),
+				Location: types.StringValue("example.txt"),
+			},
+			{
+				Base64:   types.StringValue("not base64!"),
+				Location: types.StringValue("broken.txt"),
+			},
+			{
+				Path:     types.StringValue(filepath.Join(t.TempDir(), "missing.txt")),
+				Location: types.StringValue("missing.txt"),
+			},
+		},
+	}
+
+	err := (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false)
+	require.Error(t, err)
+
+	d := errdiag("failed to generate archive", err)
+	require.Equal(t, "failed to generate archive", d.Summary())
+	require.Contains(t, d.Detail(), "invalid base64 content")
+	withpath, ok := d.(diag.DiagnosticWithPath)
+	require.True(t, ok)
+	require.Equal(t, path.Root("source").AtListIndex(1).AtName("base64"), withpath.Path())
+
+	data.Sources = data.Sources[2:]
+	err = (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false)
+	require.Error(t, err)
+	withpath, ok = errdiag("failed to generate archive", err).(diag.DiagnosticWithPath)
+	require.True(t, ok)
+	require.Equal(t, path.Root("source").AtListIndex(0).AtName("path"), withpath.Path())
+}
+
+func TestErrdiagClasses(t *testing.T) {
+	d := errdiag("failed", errorsx.Timedout(errorsx.New("boom"), time.Second))
+	require.Equal(t, "failed: timed out after 1s", d.Summary())
+
+	d = errdiag("failed", errorsx.Wrap(errorsx.Authorization(errorsx.New("denied")), "request"))
+	require.Equal(t, "failed: unauthorized", d.Summary())
+
+	d = errdiag("failed", errorsx.Wrap(errorsx.UserFriendly(errorsx.New("friendly message")), "internal context"))
+	require.Equal(t, "friendly message", d.Detail())
+}
+
+func TestArchiveResourceReadIdempotent(t *testing.T) {
+	ctx := context.Background()
+	r := &ArchiveResource{}
+
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+	require.False(t, schemaresp.Diagnostics.HasError())
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "example.txt")
+	require.NoError(t, os.WriteFile(src, []byte("hello world"), 0600))
+
+	data := ArchiveResourceModel{
+		Compression:     types.StringValue(string(tarx.CodecGzip)),
+		OutputPath:      types.StringValue(filepath.Join(dir, "example.tar.gz")),
+		OutputFilePerm:  types.Int32Value(0600),
+		OutputDirPerm:   types.Int32Value(0750),
+		StoreArchiveB64: types.BoolValue(true),
+		Reproducible:    types.BoolValue(false),
+		DirPerms:        types.MapNull(types.Int32Type),
+		Timestamp:       types.Int64Value(time.Now().UnixMilli()),
+		Sources:         []*SourceModel{newsource()},
+	}
+	data.Sources[0].Path = types.StringValue(src)
+	data.Sources[0].Location = types.StringValue("example.txt")
+	require.NoError(t, r.build(ctx, time.UnixMilli(data.Timestamp.ValueInt64()), &data, true))
+
+	state := tfsdk.State{Schema: schemaresp.Schema}
+	require.False(t, state.Set(ctx, &data).HasError())
+
+	read := func() *resource.ReadResponse {
+		resp := &resource.ReadResponse{State: tfsdk.State{Schema: state.Schema, Raw: state.Raw.Copy()}}
+		r.Read(ctx, resource.ReadRequest{State: state}, resp)
+		require.False(t, resp.Diagnostics.HasError(), "%v", resp.Diagnostics)
+		return resp
+	}
+
+	// modifying the source does not alter the archive during refresh.
+	require.NoError(t, os.WriteFile(src, []byte("modified"), 0600))
+	for i := 0; i < 2; i++ {
+		require.True(t, read().State.Raw.Equal(state.Raw))
+	}
+
+	require.NoError(t, os.Remove(data.OutputPath.ValueString()))
+	require.True(t, read().State.Raw.IsNull())
+}
+
+func TestArchiveResourceGenerateParentDirectories(t *testing.T) {
+	source := func(location string) *SourceModel {
+		src := newsource()
+		src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(location)))
+		src.Location = types.StringValue(location)
+		return src
+	}
+
+	data := ArchiveResourceModel{
+		ParentDirs: types.BoolValue(true),
+		DirPerm:    types.Int32Value(0750),
+		DirPerms:   types.MapValueMust(types.Int32Type, map[string]attr.Value{"etc/app/": types.Int32Value(0700)}),
+		Sources: []*SourceModel{
+			source("etc/app/config.yaml"),
+			source("etc/app/other.yaml"),
+			source("usr/bin/agent"),
+			source("README"),
+		},
+	}
+
+	require.NoError(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+
+	type summary struct {
+		name     string
+		typeflag byte
+		mode     int64
+	}
+
+	actual := []summary{}
+	for _, e := range readarchiveb64(t, data.ArchiveB64.ValueString()) {
+		actual = append(actual, summary{name: e.header.Name, typeflag: e.header.Typeflag, mode: e.header.Mode})
+	}
+
+	require.Equal(t, []summary{
+		{name: "etc/", typeflag: tar.TypeDir, mode: 0750},
+		{name: "etc/app/", typeflag: tar.TypeDir, mode: 0700},
+		{name: "etc/app/config.yaml", typeflag: tar.TypeReg, mode: 0600},
+		{name: "etc/app/other.yaml", typeflag: tar.TypeReg, mode: 0600},
+		{name: "usr/", typeflag: tar.TypeDir, mode: 0750},
+		{name: "usr/bin/", typeflag: tar.TypeDir, mode: 0750},
+		{name: "usr/bin/agent", typeflag: tar.TypeReg, mode: 0600},
+		{name: "README", typeflag: tar.TypeReg, mode: 0600},
+	}, actual)
+}
+
+func TestArchiveResourceGenerateLinks(t *testing.T) {
+	file := func(location string) *SourceModel {
+		src := newsource()
+		src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(location)))
+		src.Location = types.StringValue(location)
+		return src
+	}
+
+	symlink := func(location, target string) *SourceModel {
+		src := newsource()
+		src.Symlink = types.StringValue(target)
+		src.Location = types.StringValue(location)
+		return src
+	}
+
+	hardlink := func(location, target string) *SourceModel {
+		src := newsource()
+		src.Hardlink = types.StringValue(target)
+		src.Location = types.StringValue(location)
+		return src
+	}
+
+	t.Run("typeflags", func(t *testing.T) {
+		data := ArchiveResourceModel{
+			Reproducible: types.BoolValue(true),
+			Sources: []*SourceModel{
+				hardlink("bin/busybox", "usr/bin/busybox"),
+				symlink("lib", "usr/lib"),
+				file("usr/bin/busybox"),
+			},
+		}
+
+		require.NoError(t, (&ArchiveResource{}).build(context.Background(), time.Unix(0, 0), &data, false))
+
+		entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+		require.Len(t, entries, 3)
+		require.Equal(t, "lib", entries[0].header.Name)
+		require.Equal(t, byte(tar.TypeSymlink), entries[0].header.Typeflag)
+		require.Equal(t, "usr/lib", entries[0].header.Linkname)
+		require.Equal(t, int64(0777), entries[0].header.Mode)
+		require.Equal(t, "usr/bin/busybox", entries[1].header.Name)
+		require.Equal(t, "bin/busybox", entries[2].header.Name)
+		require.Equal(t, byte(tar.TypeLink), entries[2].header.Typeflag)
+		require.Equal(t, "usr/bin/busybox", entries[2].header.Linkname)
+	})
+
+	t.Run("hardlink target must precede", func(t *testing.T) {
+		data := ArchiveResourceModel{
+			Sources: []*SourceModel{
+				hardlink("bin/busybox", "usr/bin/busybox"),
+				file("usr/bin/busybox"),
+			},
+		}
+
+		err := (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false)
+		require.Error(t, err)
+		withpath, ok := errdiag("failed", err).(diag.DiagnosticWithPath)
+		require.True(t, ok)
+		require.Equal(t, path.Root("source").AtListIndex(0).AtName("hardlink"), withpath.Path())
+
+		var diags diag.Diagnostics
+		validatelinks(&data, &diags)
+		require.Len(t, diags, 1)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		data := ArchiveResourceModel{
+			StrictLinks: types.BoolValue(true),
+			Sources: []*SourceModel{
+				symlink("etc/passwd", "../../etc/passwd"),
+			},
+		}
+
+		require.Error(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+
+		var diags diag.Diagnostics
+		validatelinks(&data, &diags)
+		require.Len(t, diags, 1)
+		require.Equal(t, path.Root("source").AtListIndex(0).AtName("symlink"), diags[0].(diag.DiagnosticWithPath).Path())
+
+		data.StrictLinks = types.BoolValue(false)
+		require.NoError(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+	})
+}
+
+func TestArchiveResourceGenerateTemplate(t *testing.T) {
+	template := func(body string, vars map[string]string) *SourceModel {
+		values := map[string]attr.Value{}
+		for k, v := range vars {
+			values[k] = types.StringValue(v)
+		}
+
+		src := newsource()
+		src.Template = types.StringValue(body)
+		src.Vars = types.MapValueMust(types.StringType, values)
+		src.Location = types.StringValue("etc/agent.yaml")
+		return src
+	}
+
+	t.Run("render", func(t *testing.T) {
+		data := ArchiveResourceModel{
+			Sources: []*SourceModel{
+				template("endpoint: {{ .endpoint }}\n", map[string]string{"endpoint": "https://example.com"}),
+			},
+		}
+
+		require.NoError(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+
+		entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+		require.Len(t, entries, 1)
+		require.Equal(t, "endpoint: https://example.com\n", string(entries[0].contents))
+
+		digest := sha256.Sum256(entries[0].contents)
+		require.Equal(t, hex.EncodeToString(digest[:]), data.Sources[0].Digest.ValueString())
+	})
+
+	t.Run("diagnostics", func(t *testing.T) {
+		for _, src := range []*SourceModel{
+			template("endpoint: {{ .endpoint ", nil),
+			template("endpoint: {{ .missing }}\n", map[string]string{"endpoint": "https://example.com"}),
+		} {
+			data := ArchiveResourceModel{Sources: []*SourceModel{src}}
+
+			err := (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false)
+			require.Error(t, err)
+			withpath, ok := errdiag("failed", err).(diag.DiagnosticWithPath)
+			require.True(t, ok)
+			require.Equal(t, path.Root("source").AtListIndex(0).AtName("template"), withpath.Path())
+
+			var diags diag.Diagnostics
+			validatetemplates(context.Background(), &data, &diags)
+			require.Len(t, diags, 1)
+			require.Contains(t, diags[0].Detail(), "etc/agent.yaml")
+		}
+	})
+}
+
+func TestArchiveResourceGenerateArchive(t *testing.T) {
+	upstream := bytes.Buffer{}
+	cw, err := tarx.CodecGzip.NewWriter(&upstream, tarx.LevelDefault)
+	require.NoError(t, err)
+	tw := tar.NewWriter(cw)
+	for _, e := range []struct {
+		hdr      tar.Header
+		contents string
+	}{
+		{hdr: tar.Header{Name: "./pkg/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "./pkg/bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Uid: 1000, Uname: "build"}, contents: "upstream tool"},
+		{hdr: tar.Header{Name: "./pkg/bin/alias", Typeflag: tar.TypeLink, Linkname: "./pkg/bin/tool"}},
+		{hdr: tar.Header{Name: "./pkg/README", Typeflag: tar.TypeReg, Mode: 0644}, contents: "readme"},
+		{hdr: tar.Header{Name: "./pkg/etc/config.yaml", Typeflag: tar.TypeReg, Mode: 0644}, contents: "upstream config"},
+		{hdr: tar.Header{Name: "./other/file", Typeflag: tar.TypeReg, Mode: 0644}, contents: "other"},
+	} {
+		e.hdr.Size = int64(len(e.contents))
+		require.NoError(t, tw.WriteHeader(&e.hdr))
+		_, err = io.WriteString(tw, e.contents)
+		require.NoError(t, err)
+	}
+	require.NoError(t, errorsx.Compact(tw.Close(), cw.Close()))
+
+	overlay := newsource()
+	overlay.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("overlay config")))
+	overlay.Location = types.StringValue("opt/tool/etc/config.yaml")
+
+	archived := newsource()
+	archived.ArchiveB64 = types.StringValue(base64.StdEncoding.EncodeToString(upstream.Bytes()))
+	archived.StripPrefix = types.StringValue("pkg")
+	archived.Prefix = types.StringValue("opt/tool")
+	archived.Exclude = types.ListValueMust(types.StringType, []attr.Value{types.StringValue("README")})
+
+	data := ArchiveResourceModel{
+		Sources: []*SourceModel{archived, overlay},
+	}
+
+	require.NoError(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+
+	type summary struct {
+		name     string
+		typeflag byte
+		linkname string
+		contents string
+	}
+
+	actual := []summary{}
+	entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+	for _, e := range entries {
+		actual = append(actual, summary{name: e.header.Name, typeflag: e.header.Typeflag, linkname: e.header.Linkname, contents: string(e.contents)})
+	}
+
+	require.Equal(t, []summary{
+		{name: "opt/tool/bin/tool", typeflag: tar.TypeReg, contents: "upstream tool"},
+		{name: "opt/tool/bin/alias", typeflag: tar.TypeLink, linkname: "opt/tool/bin/tool"},
+		{name: "opt/tool/etc/config.yaml", typeflag: tar.TypeReg, contents: "overlay config"},
+	}, actual)
+	require.Equal(t, int64(0755), entries[0].header.Mode)
+	require.Equal(t, 1000, entries[0].header.Uid)
+	require.Equal(t, "build", entries[0].header.Uname)
+
+	digests := map[string]string{}
+	require.False(t, data.Sources[0].Digests.ElementsAs(context.Background(), &digests, false).HasError())
+	require.Contains(t, digests, "opt/tool/etc/config.yaml")
+	require.NotContains(t, digests, "opt/tool/README")
+
+	archived.StripPrefix = types.StringNull()
+	archived.Prefix = types.StringNull()
+	archived.Exclude = types.ListNull(types.StringType)
+	archived.ArchiveB64 = types.StringValue(base64.StdEncoding.EncodeToString(func() []byte {
+		buf := bytes.Buffer{}
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg}))
+		require.NoError(t, tw.Close())
+		return buf.Bytes()
+	}()))
+	require.Error(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+}
+
+func TestArchiveSourceCursor(t *testing.T) {
+	encoded := bytes.Buffer{}
+	tw := tar.NewWriter(&encoded)
+	for i := 0; i < 5; i++ {
+		contents := fmt.Sprintf("member %d", i)
+		require.NoError(t, tarx.WriteFileToArchive(tw, tarx.NewHeader(fmt.Sprint(i), time.Now(), int64(len(contents)), 0644), bytes.NewReader([]byte(contents))))
+	}
+	require.NoError(t, tw.Close())
+
+	opened := 0
+	c := &cursor{open: func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(bytes.NewReader(encoded.Bytes())), nil
+	}}
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		c.want(i)
+	}
+
+	read := func(idx int) string {
+		r, err := c.member(idx)
+		require.NoError(t, err)
+		contents, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(contents)
+	}
+
+	// members read out of order are staged instead of decompressing the archive again.
+	for _, idx := range []int{3, 1, 4, 0, 2} {
+		require.Equal(t, fmt.Sprintf("member %d", idx), read(idx))
+	}
+	require.Equal(t, 1, opened)
+
+	// the streamed member was not staged, reading it again reopens the archive.
+	require.Equal(t, "member 2", read(2))
+	require.Equal(t, "member 3", read(3))
+	require.Equal(t, 2, opened)
+}
+
+func TestArchiveResourceModifyPlanContent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	upstream := bytes.Buffer{}
+	tw := tar.NewWriter(&upstream)
+	require.NoError(t, tarx.WriteFileToArchive(tw, tarx.NewHeader("bin/tool", time.Now(), 13, 0755), bytes.NewReader([]byte("upstream tool"))))
+	require.NoError(t, tw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "upstream.tar"), upstream.Bytes(), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("key: value\n"), 0600))
+
+	archived := newsource()
+	archived.Archive = types.StringValue(filepath.Join(dir, "upstream.tar"))
+	archived.Prefix = types.StringValue("opt")
+
+	file := newsource()
+	file.Path = types.StringValue(filepath.Join(dir, "config.yaml"))
+	file.Location = types.StringValue("etc/config.yaml")
+
+	link := newsource()
+	link.Symlink = types.StringValue("opt/bin/tool")
+	link.Location = types.StringValue("tool")
+
+	data := plannedmodel(archived, file, link)
+	planned, diags := modifyplan(t, &ArchiveResource{}, data, nil)
+	require.False(t, diags.HasError(), "%v", diags)
+
+	// the planned attributes match those of the archive once built.
+	require.NoError(t, (&ArchiveResource{}).build(ctx, time.Now(), &data, false))
+	for i, v := range data.Sources {
+		require.Equal(t, v.Digest, planned.Sources[i].Digest)
+		require.Equal(t, v.Digests, planned.Sources[i].Digests)
+	}
+	require.Equal(t, data.Manifest, planned.Manifest)
+
+	// archive members are hashed while they are listed, their content is not read again.
+	entries, err := archived.extract(ctx)
+	require.NoError(t, err)
+	defer closeentries(entries...)
+	require.NoError(t, os.Remove(filepath.Join(dir, "upstream.tar")))
+	digest, err := entries[0].digest()
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(func() []byte { d := sha256.Sum256([]byte("upstream tool")); return d[:] }()), digest)
+
+	// content produced during apply leaves the attributes derived from it unknown.
+	planned, diags = modifyplan(t, &ArchiveResource{}, plannedmodel(archived, file, link), nil)
+	require.False(t, diags.HasError(), "%v", diags)
+	require.True(t, planned.Sources[0].Digest.IsUnknown())
+	require.True(t, planned.Sources[0].Digests.IsUnknown())
+	require.Equal(t, data.Sources[1].Digest, planned.Sources[1].Digest)
+	require.Equal(t, data.Sources[2].Digests, planned.Sources[2].Digests)
+	require.True(t, planned.Manifest.IsUnknown())
+	require.True(t, planned.Timestamp.IsUnknown())
+}
+
+func TestArchiveResourceDigestAlgorithms(t *testing.T) {
+	for algorithm, sum := range map[string]func([]byte) []byte{
+		digestSHA256: func(b []byte) []byte { d := sha256.Sum256(b); return d[:] },
+		digestSHA384: func(b []byte) []byte { d := sha512.Sum384(b); return d[:] },
+		digestSHA512: func(b []byte) []byte { d := sha512.Sum512(b); return d[:] },
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			src := newsource()
+			src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world")))
+			src.Location = types.StringValue("example.txt")
+
+			data := ArchiveResourceModel{
+				DigestAlgorithm: types.StringValue(algorithm),
+				Sources:         []*SourceModel{src},
+			}
+
+			require.NoError(t, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false))
+
+			archive, err := base64.StdEncoding.DecodeString(data.ArchiveB64.ValueString())
+			require.NoError(t, err)
+			require.Equal(t, hex.EncodeToString(sum(archive)), data.ArchiveDigest.ValueString())
+			require.Equal(t, int64(len(archive)), data.ArchiveSize.ValueInt64())
+			require.Equal(t, hex.EncodeToString(sum([]byte("hello world"))), data.ContentDigest.ValueString())
+			require.Equal(t, data.ContentDigest, data.Digest)
+			require.Equal(t, hex.EncodeToString(sum([]byte("hello world"))), data.Sources[0].Digest.ValueString())
+
+			reason, err := (&ArchiveResource{}).drifted(&data)
+			require.NoError(t, err)
+			require.Empty(t, reason)
+		})
+	}
+}
+
+// BenchmarkArchiveResourceBuild demonstrates memory usage (B/op) remains flat
+// as the size of the archived content grows.
+func BenchmarkArchiveResourceBuild(b *testing.B) {
+	for _, size := range []int64{1 << 20, 16 << 20, 64 << 20} {
+		dir := b.TempDir()
+		content := filepath.Join(dir, "content.bin")
+		upstream := filepath.Join(dir, "upstream.tar")
+
+		dst, err := os.Create(content)
+		require.NoError(b, err)
+		_, err = io.CopyN(dst, rand.New(rand.NewSource(size)), size)
+		require.NoError(b, errorsx.Compact(err, dst.Close()))
+
+		dst, err = os.Create(upstream)
+		require.NoError(b, err)
+		require.NoError(b, errorsx.Compact(tarx.PackWith(dst, tarx.CodecNone, tarx.LevelDefault, content), dst.Close()))
+
+		for name, source := range map[string]func() *SourceModel{
+			"path": func() *SourceModel {
+				src := newsource()
+				src.Path = types.StringValue(content)
+				src.Location = types.StringValue("content.bin")
+				return src
+			},
+			"archive": func() *SourceModel {
+				src := newsource()
+				src.Archive = types.StringValue(upstream)
+				return src
+			},
+		} {
+			b.Run(fmt.Sprintf("%s/%dMiB", name, size>>20), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(size)
+				for i := 0; i < b.N; i++ {
+					data := plannedmodel(source())
+					data.Compression = types.StringValue(string(tarx.CodecGzip))
+					data.CompressionLevel = types.Int64Value(1)
+					data.StoreArchiveB64 = types.BoolValue(false)
+					data.OutputPath = types.StringValue(filepath.Join(b.TempDir(), "archive.tar.gz"))
+					require.NoError(b, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, true))
+				}
+			})
+		}
+	}
+}
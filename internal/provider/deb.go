@@ -0,0 +1,419 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/egdaemon/egt/internal/debx"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+var (
+	debpackage = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+	debversion = regexp.MustCompile(`^([0-9]+:)?[0-9][A-Za-z0-9.+~-]*$`)
+	debfield   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+)
+
+// DebResourceModel describes a debian package, an archive of the package's
+// files along with its control information.
+type DebResourceModel struct {
+	ArchiveResourceModel
+	Control   *DebControlModel `tfsdk:"control"`
+	Preinst   types.String     `tfsdk:"preinst"`
+	Postinst  types.String     `tfsdk:"postinst"`
+	Prerm     types.String     `tfsdk:"prerm"`
+	Postrm    types.String     `tfsdk:"postrm"`
+	Conffiles types.List       `tfsdk:"conffiles"`
+	Filename  types.String     `tfsdk:"filename"`
+}
+
+// DebControlModel describes the control fields of a debian package.
+type DebControlModel struct {
+	Package       types.String `tfsdk:"package"`
+	Version       types.String `tfsdk:"version"`
+	Architecture  types.String `tfsdk:"architecture"`
+	Maintainer    types.String `tfsdk:"maintainer"`
+	Description   types.String `tfsdk:"description"`
+	Section       types.String `tfsdk:"section"`
+	Priority      types.String `tfsdk:"priority"`
+	Homepage      types.String `tfsdk:"homepage"`
+	Essential     types.Bool   `tfsdk:"essential"`
+	InstalledSize types.Int64  `tfsdk:"installed_size"`
+	PreDepends    types.List   `tfsdk:"pre_depends"`
+	Depends       types.List   `tfsdk:"depends"`
+	Recommends    types.List   `tfsdk:"recommends"`
+	Suggests      types.List   `tfsdk:"suggests"`
+	Breaks        types.List   `tfsdk:"breaks"`
+	Conflicts     types.List   `tfsdk:"conflicts"`
+	Replaces      types.List   `tfsdk:"replaces"`
+	Provides      types.List   `tfsdk:"provides"`
+	Fields        types.Map    `tfsdk:"fields"`
+}
+
+// pkg converts the model into the control information of the package.
+func (t *DebResourceModel) pkg(ctx context.Context) (pkg debx.Package, diags diag.Diagnostics) {
+	c := t.Control
+	pkg = debx.Package{
+		Control: debx.Control{
+			Package:       c.Package.ValueString(),
+			Version:       c.Version.ValueString(),
+			Architecture:  c.Architecture.ValueString(),
+			Maintainer:    c.Maintainer.ValueString(),
+			Description:   c.Description.ValueString(),
+			Section:       c.Section.ValueString(),
+			Priority:      c.Priority.ValueString(),
+			Homepage:      c.Homepage.ValueString(),
+			Essential:     c.Essential.ValueBool(),
+			InstalledSize: c.InstalledSize.ValueInt64(),
+		},
+		Scripts: map[string]string{
+			"preinst":  t.Preinst.ValueString(),
+			"postinst": t.Postinst.ValueString(),
+			"prerm":    t.Prerm.ValueString(),
+			"postrm":   t.Postrm.ValueString(),
+		},
+	}
+
+	for src, dst := range map[*types.List]*[]string{
+		&c.PreDepends: &pkg.Control.PreDepends,
+		&c.Depends:    &pkg.Control.Depends,
+		&c.Recommends: &pkg.Control.Recommends,
+		&c.Suggests:   &pkg.Control.Suggests,
+		&c.Breaks:     &pkg.Control.Breaks,
+		&c.Conflicts:  &pkg.Control.Conflicts,
+		&c.Replaces:   &pkg.Control.Replaces,
+		&c.Provides:   &pkg.Control.Provides,
+		&t.Conffiles:  &pkg.Conffiles,
+	} {
+		diags.Append(src.ElementsAs(ctx, dst, false)...)
+	}
+
+	diags.Append(c.Fields.ElementsAs(ctx, &pkg.Control.Fields, false)...)
+	return pkg, diags
+}
+
+// filename of the package following the debian conventions, the epoch of the version is omitted.
+func (t *DebResourceModel) filename() types.String {
+	version := t.Control.Version.ValueString()
+	if _, v, ok := strings.Cut(version, ":"); ok {
+		version = v
+	}
+
+	return basetypes.NewStringValue(fmt.Sprintf("%s_%s_%s.deb", t.Control.Package.ValueString(), version, t.Control.Architecture.ValueString()))
+}
+
+// formatdeb of packages with the control information.
+func formatdeb(pkg debx.Package) *format {
+	return &format{
+		name:                   "deb",
+		description:            "creates a reproducible debian binary package, the data archive is built from the sources and the md5sums of every file are generated automatically",
+		unsupported:            []string{"whiteout", "opaque"},
+		compressions:           codecnames(debx.WritableCodecs()...),
+		compression:            string(tarx.CodecXz),
+		compressiondescription: "compression codec applied to the control and data archives, one of none, gzip, xz or zstd. defaults to xz",
+		leveldescription:       "compression level of the codec; gzip 0-9, zstd 1-22, xz 0-9. defaults to the codec's default level",
+		levels: func(compression string) (int, int) {
+			return tarx.Codec(compression).Levels()
+		},
+		mimetype: func(compression string) string {
+			return debx.Mimetype
+		},
+		writer: func(dst io.Writer, compression string, level int) (archiver, error) {
+			return debx.NewWriter(dst, pkg, tarx.Codec(compression), level)
+		},
+	}
+}
+
+func NewDebResource() resource.Resource {
+	return &DebResource{ArchiveResource: ArchiveResource{format: formatdeb(debx.Package{})}}
+}
+
+// DebResource generates debian packages from the sources of an archive.
+type DebResource struct {
+	ArchiveResource
+}
+
+// packager of the package, an archive resource writing the control information.
+func (r *DebResource) packager(pkg debx.Package) *ArchiveResource {
+	packager := r.ArchiveResource
+	packager.format = formatdeb(pkg)
+	return &packager
+}
+
+func (r *DebResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	r.ArchiveResource.Schema(ctx, req, resp)
+
+	// dpkg does not create the parents of the package's entries.
+	parents := resp.Schema.Attributes["parent_directories"].(schema.BoolAttribute)
+	parents.MarkdownDescription = "emit a directory entry for every parent of an entry's location before the entry itself. defaults to true"
+	parents.Default = booldefault.StaticBool(true)
+	resp.Schema.Attributes["parent_directories"] = parents
+
+	// packages are reproducible unless explicitly disabled.
+	reproducible := resp.Schema.Attributes["reproducible"].(schema.BoolAttribute)
+	reproducible.MarkdownDescription = "produce bit for bit reproducible packages; entries are sorted by location, headers are normalized, ownership not set on a source is reset to root and the timestamp is the source date epoch. defaults to true"
+	reproducible.Default = booldefault.StaticBool(true)
+	resp.Schema.Attributes["reproducible"] = reproducible
+
+	relations := func(field string) schema.ListAttribute {
+		return schema.ListAttribute{
+			MarkdownDescription: fmt.Sprintf("relationships of the %s field, e.g. `libc6 (>= 2.34)` or `default-mta | mail-transport-agent`", field),
+			ElementType:         types.StringType,
+			Optional:            true,
+		}
+	}
+
+	script := func(name string) schema.StringAttribute {
+		return schema.StringAttribute{
+			MarkdownDescription: fmt.Sprintf("content of the %s maintainer script", name),
+			Optional:            true,
+			Validators: []validator.String{
+				stringvalidator.RegexMatches(regexp.MustCompile(`^#!`), "must start with an interpreter line, e.g. #!/bin/sh"),
+			},
+		}
+	}
+
+	resp.Schema.Attributes["control"] = schema.SingleNestedAttribute{
+		MarkdownDescription: "fields of the package's control file, Installed-Size is computed from the entries unless provided",
+		Required:            true,
+		Attributes: map[string]schema.Attribute{
+			"package": schema.StringAttribute{
+				MarkdownDescription: "name of the package",
+				Required:            true,
+				Validators: []validator.String{
+					stringvalidator.RegexMatches(debpackage, "must consist of lower case letters, digits, +, - and . starting with an alphanumeric character"),
+				},
+			},
+			"version": schema.StringAttribute{
+				MarkdownDescription: "version of the package as [epoch:]upstream_version[-debian_revision]",
+				Required:            true,
+				Validators: []validator.String{
+					stringvalidator.RegexMatches(debversion, "must be a debian version, [epoch:]upstream_version[-debian_revision]"),
+				},
+			},
+			"architecture": schema.StringAttribute{
+				MarkdownDescription: "architecture of the package, e.g. amd64 or all",
+				Required:            true,
+			},
+			"maintainer": schema.StringAttribute{
+				MarkdownDescription: "maintainer of the package, e.g. `Jane Doe <jane@example.com>`",
+				Required:            true,
+			},
+			"description": schema.StringAttribute{
+				MarkdownDescription: "description of the package, the first line is the synopsis and the remaining lines the extended description",
+				Required:            true,
+				Validators: []validator.String{
+					stringvalidator.LengthAtLeast(1),
+				},
+			},
+			"section": schema.StringAttribute{
+				MarkdownDescription: "section of the package, e.g. admin",
+				Optional:            true,
+			},
+			"priority": schema.StringAttribute{
+				MarkdownDescription: "priority of the package, e.g. optional",
+				Optional:            true,
+			},
+			"homepage": schema.StringAttribute{
+				MarkdownDescription: "homepage of the package",
+				Optional:            true,
+			},
+			"essential": schema.BoolAttribute{
+				MarkdownDescription: "mark the package as essential",
+				Optional:            true,
+			},
+			"installed_size": schema.Int64Attribute{
+				MarkdownDescription: "estimated installed size in KiB, computed from the entries of the package when omitted",
+				Optional:            true,
+			},
+			"pre_depends": relations("Pre-Depends"),
+			"depends":     relations("Depends"),
+			"recommends":  relations("Recommends"),
+			"suggests":    relations("Suggests"),
+			"breaks":      relations("Breaks"),
+			"conflicts":   relations("Conflicts"),
+			"replaces":    relations("Replaces"),
+			"provides":    relations("Provides"),
+			"fields": schema.MapAttribute{
+				MarkdownDescription: "additional control fields keyed by name, e.g. Built-Using",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+		},
+	}
+
+	for _, name := range debx.Scripts() {
+		resp.Schema.Attributes[name] = script(name)
+	}
+
+	resp.Schema.Attributes["conffiles"] = schema.ListAttribute{
+		MarkdownDescription: "absolute paths of the package's configuration files, preserved by dpkg when modified locally",
+		ElementType:         types.StringType,
+		Optional:            true,
+		Validators: []validator.List{
+			listvalidator.ValueStringsAre(stringvalidator.RegexMatches(regexp.MustCompile(`^/`), "must be an absolute path")),
+		},
+	}
+
+	resp.Schema.Attributes["filename"] = schema.StringAttribute{
+		MarkdownDescription: "conventional filename of the package, <package>_<version>_<architecture>.deb",
+		Computed:            true,
+	}
+}
+
+func (r *DebResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var (
+		data DebResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.validate(ctx, &data.ArchiveResourceModel, &resp.Diagnostics)
+	validatedeb(&data, &resp.Diagnostics)
+}
+
+// validatedeb reports additional control fields which are malformed or
+// duplicate the structured fields.
+func validatedeb(data *DebResourceModel, diags *diag.Diagnostics) {
+	reserved := []string{
+		"package", "version", "architecture", "maintainer", "description", "section", "priority", "homepage", "essential",
+		"installed-size", "pre-depends", "depends", "recommends", "suggests", "breaks", "conflicts", "replaces", "provides",
+	}
+
+	if data.Control == nil {
+		return
+	}
+
+	for name := range data.Control.Fields.Elements() {
+		p := path.Root("control").AtName("fields").AtMapKey(name)
+		switch {
+		case !debfield.MatchString(name):
+			diags.AddAttributeError(p, "invalid control field", fmt.Sprintf("%q must consist of letters, digits and - starting with a letter", name))
+		case slices.ContainsFunc(reserved, func(v string) bool { return strings.EqualFold(v, name) }):
+			diags.AddAttributeError(p, "invalid control field", fmt.Sprintf("%s is set by its attribute within control", name))
+		}
+	}
+}
+
+func (r *DebResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	var (
+		data  DebResourceModel
+		state *DebResourceModel
+		prior *ArchiveResourceModel
+	)
+
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if state != nil {
+		prior = &state.ArchiveResourceModel
+	}
+
+	if r.plan(ctx, &data.ArchiveResourceModel, prior, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
+}
+
+func (r *DebResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var (
+		data DebResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pkg, diags := data.pkg(ctx)
+	if resp.Diagnostics.Append(diags...); resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.packager(pkg).create(ctx, &data.ArchiveResourceModel, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.Filename = data.filename()
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *DebResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var (
+		data DebResourceModel
+	)
+
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !r.exists(ctx, &data.ArchiveResourceModel, &resp.Diagnostics) {
+		resp.State.RemoveResource(ctx)
+	}
+}
+
+func (r *DebResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var (
+		data  DebResourceModel
+		prior DebResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pkg, diags := data.pkg(ctx)
+	if resp.Diagnostics.Append(diags...); resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.packager(pkg).update(ctx, &data.ArchiveResourceModel, &prior.ArchiveResourceModel, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.Filename = data.filename()
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *DebResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var (
+		data DebResourceModel
+	)
+
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.delete(&data.ArchiveResourceModel, &resp.Diagnostics)
+}
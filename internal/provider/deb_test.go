@@ -0,0 +1,224 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/defaults"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func planneddeb(sources ...*SourceModel) DebResourceModel {
+	none := types.ListNull(types.StringType)
+	data := DebResourceModel{
+		ArchiveResourceModel: plannedmodel(sources...),
+		Control: &DebControlModel{
+			Package:       types.StringValue("agent"),
+			Version:       types.StringValue("1:1.2.3-1"),
+			Architecture:  types.StringValue("amd64"),
+			Maintainer:    types.StringValue("ops <ops@example.com>"),
+			Description:   types.StringValue("monitoring agent\nreports the health of the host."),
+			Section:       types.StringNull(),
+			Priority:      types.StringNull(),
+			Homepage:      types.StringNull(),
+			Essential:     types.BoolNull(),
+			InstalledSize: types.Int64Null(),
+			PreDepends:    none,
+			Depends:       types.ListValueMust(types.StringType, []attr.Value{types.StringValue("libc6 (>= 2.34)"), types.StringValue("adduser")}),
+			Recommends:    none,
+			Suggests:      none,
+			Breaks:        none,
+			Conflicts:     none,
+			Replaces:      none,
+			Provides:      none,
+			Fields:        types.MapNull(types.StringType),
+		},
+		Preinst:   types.StringNull(),
+		Postinst:  types.StringValue("#!/bin/sh\nexit 0\n"),
+		Prerm:     types.StringNull(),
+		Postrm:    types.StringNull(),
+		Conffiles: types.ListValueMust(types.StringType, []attr.Value{types.StringValue("/etc/agent.conf")}),
+		Filename:  types.StringUnknown(),
+	}
+	data.Compression = types.StringValue(string(tarx.CodecXz))
+	data.ParentDirs = types.BoolValue(true)
+	data.Reproducible = types.BoolValue(true)
+	data.SourceDateEpoch = types.Int64Value(1700000000)
+	return data
+}
+
+// debentries of the compressed tar archive stored as the named member of the package.
+func debentries(t *testing.T, encoded []byte, name string) (names []string, contents map[string]string) {
+	require.True(t, bytes.HasPrefix(encoded, []byte("!<arch>\n")))
+	encoded = encoded[8:]
+	for len(encoded) > 0 {
+		hdr := string(encoded[:60])
+		size, err := strconv.ParseInt(strings.TrimSpace(hdr[48:58]), 10, 64)
+		require.NoError(t, err)
+		member := encoded[60 : 60+size]
+		encoded = encoded[60+size+size%2:]
+		if strings.TrimSpace(hdr[:16]) != name {
+			continue
+		}
+
+		cr, _, err := tarx.Decompress(bytes.NewReader(member))
+		require.NoError(t, err)
+		defer cr.Close()
+
+		contents = map[string]string{}
+		tr := tar.NewReader(cr)
+		for {
+			th, err := tr.Next()
+			if err == io.EOF {
+				return names, contents
+			}
+			require.NoError(t, err)
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			names = append(names, th.Name)
+			contents[th.Name] = string(b)
+		}
+	}
+
+	require.Failf(t, "missing member", "%s", name)
+	return nil, nil
+}
+
+func TestDebResourceCreate(t *testing.T) {
+	ctx := context.Background()
+	r := NewDebResource().(*DebResource)
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	binary := newsource()
+	binary.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n")))
+	binary.Location = types.StringValue("usr/bin/agent")
+	binary.Perm = types.Int32Value(0755)
+
+	conf := newsource()
+	conf.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("a=1\n")))
+	conf.Location = types.StringValue("etc/agent.conf")
+
+	create := func() DebResourceModel {
+		data := planneddeb(binary, conf)
+		plan := tfsdk.Plan{Schema: schemaresp.Schema}
+		require.False(t, plan.Set(ctx, &data).HasError())
+		resp := &resource.CreateResponse{State: tfsdk.State{Schema: schemaresp.Schema}}
+		r.Create(ctx, resource.CreateRequest{Plan: plan}, resp)
+		require.False(t, resp.Diagnostics.HasError(), "%v", resp.Diagnostics)
+
+		var created DebResourceModel
+		require.False(t, resp.State.Get(ctx, &created).HasError())
+		return created
+	}
+
+	created := create()
+	require.Equal(t, "agent_1.2.3-1_amd64.deb", created.Filename.ValueString())
+	require.Equal(t, "application/vnd.debian.binary-package", created.Mimetype.ValueString())
+	require.Equal(t, created.ArchiveB64.ValueString(), create().ArchiveB64.ValueString(), "packages must be reproducible")
+
+	decoded, err := base64.StdEncoding.DecodeString(created.ArchiveB64.ValueString())
+	require.NoError(t, err)
+
+	names, _ := debentries(t, decoded, "data.tar.xz")
+	require.Equal(t, []string{"./", "./etc/", "./etc/agent.conf", "./usr/", "./usr/bin/", "./usr/bin/agent"}, names)
+
+	names, control := debentries(t, decoded, "control.tar.xz")
+	require.Equal(t, []string{"./", "./control", "./md5sums", "./conffiles", "./postinst"}, names)
+	require.Equal(t, strings.Join([]string{
+		"Package: agent",
+		"Version: 1:1.2.3-1",
+		"Architecture: amd64",
+		"Maintainer: ops <ops@example.com>",
+		"Installed-Size: 5",
+		"Depends: libc6 (>= 2.34), adduser",
+		"Description: monitoring agent",
+		" reports the health of the host.",
+		"",
+	}, "\n"), control["./control"])
+	require.Equal(t, "/etc/agent.conf\n", control["./conffiles"])
+	require.Equal(t, "#!/bin/sh\nexit 0\n", control["./postinst"])
+	require.Contains(t, control["./md5sums"], "  etc/agent.conf\n")
+	require.Contains(t, control["./md5sums"], "  usr/bin/agent\n")
+}
+
+func TestDebResourceReproducible(t *testing.T) {
+	ctx := context.Background()
+	r := NewDebResource().(*DebResource)
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	defaulted := &defaults.BoolResponse{}
+	schemaresp.Schema.Attributes["reproducible"].(schema.BoolAttribute).Default.DefaultBool(ctx, defaults.BoolRequest{}, defaulted)
+	require.True(t, defaulted.PlanValue.ValueBool())
+
+	binary := newsource()
+	binary.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n")))
+	binary.Location = types.StringValue("usr/bin/agent")
+
+	conf := newsource()
+	conf.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("a=1\n")))
+	conf.Location = types.StringValue("etc/agent.conf")
+
+	create := func() DebResourceModel {
+		data := planneddeb(binary, conf)
+		data.Reproducible = defaulted.PlanValue
+		plan := tfsdk.Plan{Schema: schemaresp.Schema}
+		require.False(t, plan.Set(ctx, &data).HasError())
+		resp := &resource.CreateResponse{State: tfsdk.State{Schema: schemaresp.Schema}}
+		r.Create(ctx, resource.CreateRequest{Plan: plan}, resp)
+		require.False(t, resp.Diagnostics.HasError(), "%v", resp.Diagnostics)
+
+		var created DebResourceModel
+		require.False(t, resp.State.Get(ctx, &created).HasError())
+		return created
+	}
+
+	// entries are given the source date epoch rather than the time of the build.
+	first := create()
+	require.Equal(t, types.Int64Value(first.SourceDateEpoch.ValueInt64()*1000), first.Timestamp)
+
+	second := create()
+	require.Equal(t, first.ArchiveDigest, second.ArchiveDigest)
+	require.Equal(t, first.ArchiveB64.ValueString(), second.ArchiveB64.ValueString())
+}
+
+func TestDebResourceControlFields(t *testing.T) {
+	fields := func(kv ...string) DebResourceModel {
+		elements := map[string]attr.Value{}
+		for i := 0; i < len(kv); i += 2 {
+			elements[kv[i]] = types.StringValue(kv[i+1])
+		}
+
+		data := planneddeb()
+		data.Control.Fields = types.MapValueMust(types.StringType, elements)
+		return data
+	}
+
+	diags := diag.Diagnostics{}
+	data := fields("Built-Using", "golang (= 1.23)", "X-Origin", "egd")
+	validatedeb(&data, &diags)
+	require.False(t, diags.HasError(), "%v", diags)
+
+	for _, name := range []string{"depends", "Installed-Size", "1nvalid", "bad field"} {
+		diags := diag.Diagnostics{}
+		data := fields(name, "value")
+		validatedeb(&data, &diags)
+		require.True(t, diags.HasError(), name)
+		require.True(t, diags.Errors()[0].(diag.DiagnosticWithPath).Path().Equal(path.Root("control").AtName("fields").AtMapKey(name)))
+	}
+}
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+)
+
+// attributed associates an error with the attribute responsible for it.
+func attributed(p path.Path, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return attributeerror{error: err, path: p}
+}
+
+type attributeerror struct {
+	error
+	path path.Path
+}
+
+func (t attributeerror) Unwrap() error {
+	return t.error
+}
+
+func (t attributeerror) Cause() error {
+	return t.error
+}
+
+// errdiag converts an error into a diagnostic. errors associated with an
+// attribute are reported against that attribute and the errorsx classes
+// determine the summary and detail presented to the user.
+func errdiag(summary string, err error) diag.Diagnostic {
+	var (
+		attr     attributeerror
+		friendly error
+		detail   = err.Error()
+	)
+
+	if d, ok := errorsx.IsTimedout(err); ok {
+		summary = fmt.Sprintf("%s: timed out after %s", summary, d)
+	} else if errorsx.IsUnauthorized(err) {
+		summary = fmt.Sprintf("%s: unauthorized", summary)
+	}
+
+	if errorsx.IsUserFriendly(err, &friendly) {
+		detail = friendly.Error()
+	}
+
+	if errors.As(err, &attr) {
+		return diag.NewAttributeErrorDiagnostic(attr.path, summary, detail)
+	}
+
+	return diag.NewErrorDiagnostic(summary, detail)
+}
@@ -0,0 +1,25 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+)
+
+const (
+	digestSHA256 = "sha256"
+	digestSHA384 = "sha384"
+	digestSHA512 = "sha512"
+)
+
+// newhash returns the hash implementing the digest algorithm, defaulting to sha256.
+func newhash(algorithm string) hash.Hash {
+	switch algorithm {
+	case digestSHA384:
+		return sha512.New384()
+	case digestSHA512:
+		return sha512.New()
+	default:
+		return sha256.New()
+	}
+}
@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/egdaemon/egt/internal/cpiox"
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/ocix"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/egdaemon/egt/internal/zipx"
+)
+
+// archiver writes the members of an archive in a specific format.
+type archiver interface {
+	// WriteHeader writes an entry without content.
+	WriteHeader(hdr *tar.Header) error
+	// WriteFile writes an entry along with its content.
+	WriteFile(hdr *tar.Header, src io.Reader) error
+	Close() error
+}
+
+// format of the archives produced by an archive resource, every format
+// shares the source model and differs only in how the members are written.
+type format struct {
+	// name of the resource type, without the provider prefix.
+	name        string
+	description string
+	// compression codecs the format accepts along with the default.
+	compressions []string
+	compression  string
+	// descriptions of the compression and compression_level attributes.
+	compressiondescription string
+	leveldescription       string
+	// unsupported kinds of sources the format can not represent.
+	unsupported []string
+	levels      func(compression string) (lowest, highest int)
+	mimetype    func(compression string) string
+	writer      func(dst io.Writer, compression string, level int) (archiver, error)
+	// conform the header of an entry produced by a source of the kind to the
+	// conventions of the format, nil when the format has none. the kind of
+	// generated parent directories is empty.
+	conform func(hdr *tar.Header, kind string) error
+	// methods reports if sources may choose the compression method of their
+	// files, otherwise the compression applies to the archive as a whole.
+	methods bool
+}
+
+var formattar = &format{
+	name:                   "tar",
+	description:            "creates a tar archive, existing archives can be imported using their path as the import id",
+	compressions:           codecnames(tarx.WritableCodecs()...),
+	compression:            string(tarx.CodecGzip),
+	compressiondescription: "compression codec applied to the archive, one of none, gzip, zstd or xz. defaults to gzip",
+	leveldescription:       "compression level of the codec; gzip 0-9, zstd 1-22, xz 0-9. defaults to the codec's default level",
+	levels: func(compression string) (int, int) {
+		return tarx.Codec(compression).Levels()
+	},
+	mimetype: func(compression string) string {
+		return tarx.Codec(compression).Mimetype()
+	},
+	writer: func(dst io.Writer, compression string, level int) (archiver, error) {
+		cw, err := tarx.Codec(compression).NewWriter(dst, level)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tararchiver{cw: cw, tw: tar.NewWriter(cw)}, nil
+	},
+}
+
+var formatzip = &format{
+	name:                   "zip",
+	description:            "creates a zip archive retaining the unix permissions of every entry, hardlinks and device nodes are not supported",
+	unsupported:            []string{"hardlink", "device", "whiteout", "opaque"},
+	methods:                true,
+	compressions:           methodnames(zipx.Methods()...),
+	compression:            string(zipx.MethodDeflate),
+	compressiondescription: "compression method applied to each file unless its source sets a method, store or deflate. directories, symlinks and empty files are always stored. defaults to deflate",
+	leveldescription:       "compression level of deflate 0-9, defaults to deflate's default level",
+	levels: func(compression string) (int, int) {
+		return zipx.Method(compression).Levels()
+	},
+	mimetype: func(compression string) string {
+		return zipx.Mimetype
+	},
+	writer: func(dst io.Writer, compression string, level int) (archiver, error) {
+		return zipx.NewWriter(dst, zipx.Method(compression), level)
+	},
+}
+
+var formatcpio = &format{
+	name:                   "cpio",
+	description:            "creates a newc format cpio archive, e.g. a linux initramfs image. the numeric ownership, permissions and device numbers of every entry are retained, hardlinks are not supported",
+	unsupported:            []string{"hardlink", "whiteout", "opaque"},
+	compressions:           codecnames(cpiox.WritableCodecs()...),
+	compression:            string(tarx.CodecNone),
+	compressiondescription: "compression codec applied to the archive, one of none, gzip or zstd. defaults to none",
+	leveldescription:       "compression level of the codec; gzip 0-9, zstd 1-22. defaults to the codec's default level",
+	levels: func(compression string) (int, int) {
+		return tarx.Codec(compression).Levels()
+	},
+	mimetype: func(compression string) string {
+		return cpiox.Mimetype(tarx.Codec(compression))
+	},
+	writer: func(dst io.Writer, compression string, level int) (archiver, error) {
+		cw, err := tarx.Codec(compression).NewWriter(dst, level)
+		if err != nil {
+			return nil, err
+		}
+
+		return &cpioarchiver{cw: cw, cpw: cpiox.NewWriter(cw)}, nil
+	},
+}
+
+var formatocilayer = formatlayer(nil)
+
+// formatlayer of OCI image layers recording the digests of the layer once it
+// is written, nil discards them.
+func formatlayer(digests *layerdigests) *format {
+	return &format{
+		name:                   "oci_layer",
+		description:            "creates an OCI image layer, a tar archive recording numeric ownership only. deletions from lower layers are expressed using whiteout and opaque sources",
+		compressions:           codecnames(tarx.CodecNone, tarx.CodecGzip, tarx.CodecZstd),
+		compression:            string(tarx.CodecGzip),
+		compressiondescription: "compression codec applied to the layer, one of none, gzip or zstd. defaults to gzip",
+		leveldescription:       "compression level of the codec; gzip 0-9, zstd 1-22. defaults to the codec's default level",
+		levels: func(compression string) (int, int) {
+			return tarx.Codec(compression).Levels()
+		},
+		mimetype: func(compression string) string {
+			mediatype, _ := ocix.LayerMediaType(tarx.Codec(compression))
+			return mediatype
+		},
+		writer: func(dst io.Writer, compression string, level int) (archiver, error) {
+			digest, diffid := sha256.New(), sha256.New()
+			cw, err := tarx.Codec(compression).NewWriter(io.MultiWriter(dst, digest), level)
+			if err != nil {
+				return nil, err
+			}
+
+			return &layerarchiver{
+				tararchiver: tararchiver{cw: cw, tw: tar.NewWriter(io.MultiWriter(cw, diffid))},
+				digest:      digest,
+				diffid:      diffid,
+				digests:     digests,
+			}, nil
+		},
+		conform: func(hdr *tar.Header, kind string) error {
+			if strings.HasPrefix(path.Base(hdr.Name), whiteoutprefix) && kind != "whiteout" && kind != "opaque" {
+				return errorsx.Errorf("%s: names prefixed with %s are reserved for whiteout and opaque sources within OCI layers", hdr.Name, whiteoutprefix)
+			}
+
+			// ownership is resolved by the uid and gid within the image, names are not consulted.
+			hdr.Uname, hdr.Gname = "", ""
+			return nil
+		},
+	}
+}
+
+// methodical is implemented by archivers compressing each file individually.
+type methodical interface {
+	// WriteFileMethod writes an entry along with its content compressed with the method.
+	WriteFileMethod(hdr *tar.Header, method zipx.Method, src io.Reader) error
+}
+
+// tararchiver writes the members into a compressed tar archive.
+type tararchiver struct {
+	cw io.WriteCloser
+	tw *tar.Writer
+}
+
+func (t *tararchiver) WriteHeader(hdr *tar.Header) error {
+	return t.tw.WriteHeader(hdr)
+}
+
+func (t *tararchiver) WriteFile(hdr *tar.Header, src io.Reader) error {
+	return tarx.WriteFileToArchive(t.tw, hdr, src)
+}
+
+func (t *tararchiver) Close() error {
+	return errorsx.Compact(t.tw.Close(), t.cw.Close())
+}
+
+// layerdigests of an OCI image layer as `sha256:<hex>`.
+type layerdigests struct {
+	// diffid of the uncompressed layer.
+	diffid string
+	// digest of the compressed layer.
+	digest string
+}
+
+// layerarchiver writes an OCI image layer, digesting the layer before and after compression.
+type layerarchiver struct {
+	tararchiver
+	digest  hash.Hash
+	diffid  hash.Hash
+	digests *layerdigests
+}
+
+func (t *layerarchiver) Close() error {
+	if err := t.tararchiver.Close(); err != nil {
+		return err
+	}
+
+	if t.digests != nil {
+		t.digests.diffid = "sha256:" + hex.EncodeToString(t.diffid.Sum(nil))
+		t.digests.digest = "sha256:" + hex.EncodeToString(t.digest.Sum(nil))
+	}
+
+	return nil
+}
+
+// cpioarchiver writes the members into a compressed cpio archive.
+type cpioarchiver struct {
+	cw  io.WriteCloser
+	cpw *cpiox.Writer
+}
+
+func (t *cpioarchiver) WriteHeader(hdr *tar.Header) error {
+	return t.cpw.WriteHeader(hdr)
+}
+
+func (t *cpioarchiver) WriteFile(hdr *tar.Header, src io.Reader) error {
+	return cpiox.WriteFileToArchive(t.cpw, hdr, src)
+}
+
+func (t *cpioarchiver) Close() error {
+	return errorsx.Compact(t.cpw.Close(), t.cw.Close())
+}
+
+func methodnames(methods ...zipx.Method) (names []string) {
+	for _, m := range methods {
+		names = append(names, string(m))
+	}
+
+	return names
+}
+
+// conform the header to the conventions of the format.
+func conform(f *format, hdr *tar.Header, kind string) error {
+	if f.conform == nil {
+		return nil
+	}
+
+	return f.conform(hdr, kind)
+}
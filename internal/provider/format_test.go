@@ -0,0 +1,261 @@
+package provider
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/egdaemon/egt/internal/cpiox"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/egdaemon/egt/internal/zipx"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZipResourceBuild(t *testing.T) {
+	r := &ArchiveResource{format: formatzip}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("config"), 0640))
+
+	build := func(output string, reversed bool) ArchiveResourceModel {
+		script := newsource()
+		script.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n")))
+		script.Location = types.StringValue("usr/bin/agent")
+		script.Perm = types.Int32Value(0755)
+
+		link := newsource()
+		link.Symlink = types.StringValue("usr/bin/agent")
+		link.Location = types.StringValue("agent")
+
+		directory := newsource()
+		directory.Directory = types.StringValue(dir)
+		directory.Prefix = types.StringValue("etc/agent")
+		directory.Method = types.StringValue(string(zipx.MethodStore))
+
+		data := plannedmodel(script, link, directory)
+		if reversed {
+			data.Sources = []*SourceModel{directory, link, script}
+		}
+		data.Compression = types.StringValue(string(zipx.MethodDeflate))
+		data.Reproducible = types.BoolValue(true)
+		data.SourceDateEpoch = types.Int64Value(1700000000)
+		data.ParentDirs = types.BoolValue(true)
+		data.OutputPath = types.StringValue(output)
+
+		ts, err := sourcedateepoch(data.SourceDateEpoch)
+		require.NoError(t, err)
+		require.NoError(t, r.build(context.Background(), ts, &data, true))
+		return data
+	}
+
+	output := filepath.Join(t.TempDir(), "release.zip")
+	a := build(output, false)
+	b := build(filepath.Join(t.TempDir(), "release.zip"), true)
+	require.Equal(t, a.ArchiveDigest, b.ArchiveDigest)
+	require.Equal(t, a.Manifest, b.Manifest)
+	require.Equal(t, zipx.Mimetype, a.Mimetype.ValueString())
+
+	encoded, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, a.ArchiveB64.ValueString(), base64.StdEncoding.EncodeToString(encoded))
+
+	zr, err := zip.NewReader(bytes.NewReader(encoded), int64(len(encoded)))
+	require.NoError(t, err)
+
+	type summary struct {
+		name     string
+		mode     fs.FileMode
+		method   uint16
+		contents string
+	}
+
+	summaries := make([]summary, 0, len(zr.File))
+	for _, f := range zr.File {
+		require.True(t, time.Unix(1700000000, 0).Equal(f.Modified))
+		rc, err := f.Open()
+		require.NoError(t, err)
+		contents, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		summaries = append(summaries, summary{name: f.Name, mode: f.Mode(), method: f.Method, contents: string(contents)})
+	}
+
+	require.Equal(t, []summary{
+		{name: "agent", mode: fs.ModeSymlink | 0777, method: zip.Store, contents: "usr/bin/agent"},
+		{name: "etc/", mode: fs.ModeDir | 0755, method: zip.Store},
+		{name: "etc/agent/", mode: fs.ModeDir | 0755, method: zip.Store},
+		{name: "etc/agent/config.yaml", mode: 0640, method: zip.Store, contents: "config"},
+		{name: "usr/", mode: fs.ModeDir | 0755, method: zip.Store},
+		{name: "usr/bin/", mode: fs.ModeDir | 0755, method: zip.Store},
+		{name: "usr/bin/agent", mode: 0755, method: zip.Deflate, contents: "#!/bin/sh\n"},
+	}, summaries)
+
+	var manifest []ManifestModel
+	require.False(t, a.Manifest.ElementsAs(context.Background(), &manifest, false).HasError())
+	require.Len(t, manifest, len(summaries))
+	for i, m := range manifest {
+		require.Equal(t, strings.TrimSuffix(summaries[i].name, "/"), m.Location.ValueString())
+	}
+
+	reason, err := r.drifted(&a)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+}
+
+func TestZipResourceUnsupportedEntries(t *testing.T) {
+	ctx := context.Background()
+	r := &ArchiveResource{format: formatzip}
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	file := newsource()
+	file.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world")))
+	file.Location = types.StringValue("example.txt")
+
+	link := newsource()
+	link.Hardlink = types.StringValue("example.txt")
+	link.Location = types.StringValue("alias.txt")
+
+	data := plannedmodel(file, link)
+	data.Compression = types.StringValue(string(zipx.MethodDeflate))
+	plan := tfsdk.Plan{Schema: schemaresp.Schema}
+	require.False(t, plan.Set(ctx, &data).HasError())
+	config := tfsdk.Config{Schema: schemaresp.Schema, Raw: plan.Raw}
+
+	resp := &resource.ValidateConfigResponse{}
+	r.ValidateConfig(ctx, resource.ValidateConfigRequest{Config: config}, resp)
+	require.True(t, resp.Diagnostics.HasError())
+	require.Equal(t, "zip archives do not support hardlink sources", resp.Diagnostics[0].Detail())
+
+	err := r.build(ctx, time.Now(), &data, false)
+	require.ErrorContains(t, err, "zip archives do not support hardlink entries")
+	withpath, ok := errdiag("failed", err).(diag.DiagnosticWithPath)
+	require.True(t, ok)
+	require.Equal(t, path.Root("source").AtListIndex(1).AtName("hardlink"), withpath.Path())
+}
+
+func TestCpioResourceBuild(t *testing.T) {
+	r := &ArchiveResource{format: formatcpio}
+
+	init := newsource()
+	init.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\nexec /sbin/init\n")))
+	init.Location = types.StringValue("init")
+	init.Perm = types.Int32Value(0755)
+
+	console := newsource()
+	console.Device = types.StringValue("char")
+	console.Major = types.Int64Value(5)
+	console.Minor = types.Int64Value(1)
+	console.Location = types.StringValue("dev/console")
+
+	disk := newsource()
+	disk.Device = types.StringValue("block")
+	disk.Major = types.Int64Value(8)
+	disk.Location = types.StringValue("dev/sda")
+	disk.Perm = types.Int32Value(0660)
+	disk.Gid = types.Int64Value(6)
+
+	link := newsource()
+	link.Symlink = types.StringValue("../lib/systemd/systemd")
+	link.Location = types.StringValue("sbin/init")
+	link.Uid = types.Int64Value(1000)
+
+	data := plannedmodel(init, console, disk, link)
+	data.Compression = types.StringValue(string(tarx.CodecGzip))
+	data.ParentDirs = types.BoolValue(true)
+	data.Reproducible = types.BoolValue(true)
+	data.SourceDateEpoch = types.Int64Value(1700000000)
+
+	ts, err := sourcedateepoch(data.SourceDateEpoch)
+	require.NoError(t, err)
+	require.NoError(t, r.build(context.Background(), ts, &data, false))
+	require.Equal(t, "application/gzip", data.Mimetype.ValueString())
+
+	decoded, err := base64.StdEncoding.DecodeString(data.ArchiveB64.ValueString())
+	require.NoError(t, err)
+	cr, codec, err := tarx.Decompress(bytes.NewReader(decoded))
+	require.NoError(t, err)
+	defer cr.Close()
+	require.Equal(t, tarx.CodecGzip, codec)
+
+	type summary struct {
+		name     string
+		typeflag byte
+		mode     int64
+		uid, gid int
+		major    int64
+		minor    int64
+		contents string
+	}
+
+	summaries := []summary{}
+	cpr := cpiox.NewReader(cr)
+	for {
+		hdr, err := cpr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.True(t, time.Unix(1700000000, 0).Equal(hdr.ModTime))
+
+		contents, err := io.ReadAll(cpr)
+		require.NoError(t, err)
+		summaries = append(summaries, summary{name: hdr.Name, typeflag: hdr.Typeflag, mode: hdr.Mode, uid: hdr.Uid, gid: hdr.Gid, major: hdr.Devmajor, minor: hdr.Devminor, contents: string(contents) + hdr.Linkname})
+	}
+
+	require.Equal(t, []summary{
+		{name: "dev", typeflag: tar.TypeDir, mode: 0755},
+		{name: "dev/console", typeflag: tar.TypeChar, mode: 0600, major: 5, minor: 1},
+		{name: "dev/sda", typeflag: tar.TypeBlock, mode: 0660, gid: 6, major: 8},
+		{name: "init", typeflag: tar.TypeReg, mode: 0755, contents: "#!/bin/sh\nexec /sbin/init\n"},
+		{name: "sbin", typeflag: tar.TypeDir, mode: 0755},
+		{name: "sbin/init", typeflag: tar.TypeSymlink, mode: 0777, uid: 1000, contents: "../lib/systemd/systemd"},
+	}, summaries)
+
+	// device nodes are identified by their type and numbers, e.g. sha256("char 5:1").
+	require.Equal(t, types.StringValue("b2c872ca9c28dac6ef7f51f8b065f955e7a48274fef4a7cc994a24b8ae4c16e5"), console.Digest)
+}
+
+func TestZipResourceDeviceUnsupported(t *testing.T) {
+	console := newsource()
+	console.Device = types.StringValue("char")
+	console.Location = types.StringValue("dev/console")
+
+	diags := diag.Diagnostics{}
+	validateformat(formatzip, &ArchiveResourceModel{Sources: []*SourceModel{console}}, &diags)
+	require.True(t, diags.HasError())
+	require.Equal(t, path.Root("source").AtListIndex(0).AtName("device"), diags[0].(diag.DiagnosticWithPath).Path())
+
+	diags = diag.Diagnostics{}
+	validateformat(formatcpio, &ArchiveResourceModel{Sources: []*SourceModel{console}}, &diags)
+	require.False(t, diags.HasError())
+}
+
+func TestArchiveResourceMethodUnsupported(t *testing.T) {
+	file := newsource()
+	file.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world")))
+	file.Location = types.StringValue("example.txt")
+	file.Method = types.StringValue(string(zipx.MethodStore))
+
+	diags := diag.Diagnostics{}
+	validateformat(formatzip, &ArchiveResourceModel{Sources: []*SourceModel{file}}, &diags)
+	require.False(t, diags.HasError())
+
+	diags = diag.Diagnostics{}
+	validateformat(formattar, &ArchiveResourceModel{Sources: []*SourceModel{file}}, &diags)
+	require.True(t, diags.HasError())
+	require.Equal(t, path.Root("source").AtListIndex(0).AtName("method"), diags[0].(diag.DiagnosticWithPath).Path())
+}
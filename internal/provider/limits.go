@@ -0,0 +1,211 @@
+package provider
+
+import (
+	"context"
+	"math"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/hashicorp/terraform-plugin-framework-validators/float64validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	pschema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// archives expanding to less than this are exempt from the compression ratio limit.
+const ratiominimum = 1 << 20
+
+// LimitsModel guards against unexpectedly large archives.
+type LimitsModel struct {
+	MaxEntrySize        types.Int64   `tfsdk:"max_entry_size"`
+	MaxTotalSize        types.Int64   `tfsdk:"max_total_size"`
+	MaxEntries          types.Int64   `tfsdk:"max_entries"`
+	MaxCompressionRatio types.Float64 `tfsdk:"max_compression_ratio"`
+}
+
+func (t *LimitsModel) limits() (l limits) {
+	if t == nil {
+		return l
+	}
+
+	return limits{
+		entrysize: t.MaxEntrySize.ValueInt64(),
+		totalsize: t.MaxTotalSize.ValueInt64(),
+		entries:   t.MaxEntries.ValueInt64(),
+		ratio:     t.MaxCompressionRatio.ValueFloat64(),
+	}
+}
+
+const (
+	limitsMaxEntrySizeDescription        = "maximum size in bytes of a single entry"
+	limitsMaxTotalSizeDescription        = "maximum total uncompressed size in bytes of every entry"
+	limitsMaxEntriesDescription          = "maximum number of entries, including generated parent directories"
+	limitsMaxCompressionRatioDescription = "maximum ratio between the uncompressed and compressed size of archives read by archive sources, archives expanding to less than 1MiB are exempt"
+)
+
+func limitsProviderSchema() pschema.SingleNestedAttribute {
+	return pschema.SingleNestedAttribute{
+		MarkdownDescription: "limits applied to every archive, resources may only tighten them",
+		Optional:            true,
+		Attributes: map[string]pschema.Attribute{
+			"max_entry_size": pschema.Int64Attribute{
+				MarkdownDescription: limitsMaxEntrySizeDescription,
+				Optional:            true,
+				Validators:          []validator.Int64{int64validator.AtLeast(1)},
+			},
+			"max_total_size": pschema.Int64Attribute{
+				MarkdownDescription: limitsMaxTotalSizeDescription,
+				Optional:            true,
+				Validators:          []validator.Int64{int64validator.AtLeast(1)},
+			},
+			"max_entries": pschema.Int64Attribute{
+				MarkdownDescription: limitsMaxEntriesDescription,
+				Optional:            true,
+				Validators:          []validator.Int64{int64validator.AtLeast(1)},
+			},
+			"max_compression_ratio": pschema.Float64Attribute{
+				MarkdownDescription: limitsMaxCompressionRatioDescription,
+				Optional:            true,
+				Validators:          []validator.Float64{float64validator.AtLeast(1)},
+			},
+		},
+	}
+}
+
+func limitsResourceSchema() schema.SingleNestedAttribute {
+	return schema.SingleNestedAttribute{
+		MarkdownDescription: "limits applied to the archive during plan and apply, the stricter of these and the provider's limits apply",
+		Optional:            true,
+		Attributes: map[string]schema.Attribute{
+			"max_entry_size": schema.Int64Attribute{
+				MarkdownDescription: limitsMaxEntrySizeDescription,
+				Optional:            true,
+				Validators:          []validator.Int64{int64validator.AtLeast(1)},
+			},
+			"max_total_size": schema.Int64Attribute{
+				MarkdownDescription: limitsMaxTotalSizeDescription,
+				Optional:            true,
+				Validators:          []validator.Int64{int64validator.AtLeast(1)},
+			},
+			"max_entries": schema.Int64Attribute{
+				MarkdownDescription: limitsMaxEntriesDescription,
+				Optional:            true,
+				Validators:          []validator.Int64{int64validator.AtLeast(1)},
+			},
+			"max_compression_ratio": schema.Float64Attribute{
+				MarkdownDescription: limitsMaxCompressionRatioDescription,
+				Optional:            true,
+				Validators:          []validator.Float64{float64validator.AtLeast(1)},
+			},
+		},
+	}
+}
+
+// limits guard against unexpectedly large archives, zero disables a limit.
+type limits struct {
+	entrysize int64
+	totalsize int64
+	entries   int64
+	ratio     float64
+}
+
+func (t limits) enabled() bool {
+	return t != limits{}
+}
+
+// stricter combines the limits retaining the smallest of each.
+func (t limits) stricter(o limits) limits {
+	smallest := func(a, b int64) int64 {
+		if a == 0 || (b != 0 && b < a) {
+			return b
+		}
+
+		return a
+	}
+
+	ratio := t.ratio
+	if ratio == 0 || (o.ratio != 0 && o.ratio < ratio) {
+		ratio = o.ratio
+	}
+
+	return limits{
+		entrysize: smallest(t.entrysize, o.entrysize),
+		totalsize: smallest(t.totalsize, o.totalsize),
+		entries:   smallest(t.entries, o.entries),
+		ratio:     ratio,
+	}
+}
+
+// check the members of the archive against the limits.
+func (t limits) check(members ...member) error {
+	var (
+		count, total int64
+	)
+
+	for _, e := range members {
+		if e.dropped {
+			continue
+		}
+
+		p := e.path
+		if e.source != nil {
+			p = p.AtName(e.source.kind())
+		}
+
+		count++
+		total += e.header.Size
+
+		switch {
+		case t.entrysize > 0 && e.header.Size > t.entrysize:
+			return attributed(p, errorsx.UserFriendly(errorsx.Errorf("%s is %d bytes exceeding the maximum entry size of %d bytes", e.header.Name, e.header.Size, t.entrysize)))
+		case t.entries > 0 && count > t.entries:
+			return attributed(p, errorsx.UserFriendly(errorsx.Errorf("%s exceeds the maximum of %d entries", e.header.Name, t.entries)))
+		case t.totalsize > 0 && total > t.totalsize:
+			return attributed(p, errorsx.UserFriendly(errorsx.Errorf("%s brings the uncompressed size to %d bytes exceeding the maximum of %d bytes", e.header.Name, total, t.totalsize)))
+		}
+	}
+
+	return nil
+}
+
+// inflation checks the compression ratio of an archive given the bytes read
+// from the compressed archive and the bytes they expanded into.
+func (t limits) inflation(deflated, inflated int64) error {
+	if t.ratio <= 0 || inflated < ratiominimum || deflated == 0 {
+		return nil
+	}
+
+	if ratio := float64(inflated) / float64(deflated); ratio > t.ratio {
+		return errorsx.UserFriendly(errorsx.Errorf("archive expands %.0fx exceeding the maximum compression ratio of %.0fx", math.Floor(ratio), t.ratio))
+	}
+
+	return nil
+}
+
+type limitskey struct{}
+
+// withlimits associates the limits with the context.
+func withlimits(ctx context.Context, l limits) context.Context {
+	return context.WithValue(ctx, limitskey{}, l)
+}
+
+// limitsfrom returns the limits associated with the context, the zero value disables every limit.
+func limitsfrom(ctx context.Context) limits {
+	l, _ := ctx.Value(limitskey{}).(limits)
+	return l
+}
+
+// limited associates the stricter of the provider's and the archive's limits
+// with the context. the provider's limits are absent until it is configured.
+func (t *providerdata) limited(ctx context.Context, data *ArchiveResourceModel) context.Context {
+	var (
+		provider limits
+	)
+
+	if t != nil {
+		provider = t.limits
+	}
+
+	return withlimits(ctx, provider.stricter(data.Limits.limits()))
+}
@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsStricter(t *testing.T) {
+	provider := limits{entrysize: 100, totalsize: 1000, ratio: 10}
+	archive := limits{entrysize: 200, entries: 5, ratio: 5}
+
+	require.Equal(t, limits{entrysize: 100, totalsize: 1000, entries: 5, ratio: 5}, provider.stricter(archive))
+	require.Equal(t, provider, provider.stricter(limits{}))
+	require.False(t, limits{}.stricter(limits{}).enabled())
+}
+
+func TestLimitsCheck(t *testing.T) {
+	source := func(location, contents string) *SourceModel {
+		src := newsource()
+		src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(contents)))
+		src.Location = types.StringValue(location)
+		return src
+	}
+
+	check := func(l limits, sources ...*SourceModel) error {
+		data := plannedmodel(sources...)
+		members, err := resolve(withlimits(context.Background(), l), time.Time{}, &data)
+		closemembers(members...)
+		return err
+	}
+
+	sources := []*SourceModel{
+		source("etc/small.txt", "small"),
+		source("etc/large.txt", strings.Repeat("x", 64)),
+	}
+
+	require.NoError(t, check(limits{}, sources...))
+	require.NoError(t, check(limits{entrysize: 64, totalsize: 69, entries: 3}, sources...))
+
+	err := check(limits{entrysize: 32}, sources...)
+	require.ErrorContains(t, err, "etc/large.txt is 64 bytes exceeding the maximum entry size of 32 bytes")
+	withpath, ok := errdiag("failed", err).(diag.DiagnosticWithPath)
+	require.True(t, ok)
+	require.Equal(t, path.Root("source").AtListIndex(1).AtName("base64"), withpath.Path())
+
+	err = check(limits{entries: 1}, sources...)
+	require.ErrorContains(t, err, "etc/large.txt exceeds the maximum of 1 entries")
+
+	err = check(limits{totalsize: 64}, sources...)
+	require.ErrorContains(t, err, "etc/large.txt brings the uncompressed size to 69 bytes exceeding the maximum of 64 bytes")
+}
+
+func TestLimitsCompressionRatio(t *testing.T) {
+	upstream := bytes.Buffer{}
+	cw, err := tarx.CodecGzip.NewWriter(&upstream, tarx.LevelDefault)
+	require.NoError(t, err)
+	tw := tar.NewWriter(cw)
+	for _, name := range []string{"zeros.1", "zeros.2", "zeros.3"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 1 << 20}))
+		_, err = tw.Write(make([]byte, 1<<20))
+		require.NoError(t, err)
+	}
+	require.NoError(t, errorsx.Compact(tw.Close(), cw.Close()))
+
+	archived := newsource()
+	archived.ArchiveB64 = types.StringValue(base64.StdEncoding.EncodeToString(upstream.Bytes()))
+
+	_, err = archived.extract(withlimits(context.Background(), limits{ratio: 10000}))
+	require.NoError(t, err)
+
+	_, err = archived.extract(withlimits(context.Background(), limits{ratio: 100}))
+	require.ErrorContains(t, err, "exceeding the maximum compression ratio of 100x")
+}
+
+func TestArchiveResourceModifyPlanLimits(t *testing.T) {
+	ctx := context.Background()
+	r := &ArchiveResource{provider: &providerdata{limits: limits{entries: 10}}}
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	src := newsource()
+	src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world")))
+	src.Location = types.StringValue("a/b/c/example.txt")
+
+	modify := func(data ArchiveResourceModel) *resource.ModifyPlanResponse {
+		plan := tfsdk.Plan{Schema: schemaresp.Schema}
+		require.False(t, plan.Set(ctx, &data).HasError())
+		resp := &resource.ModifyPlanResponse{Plan: plan}
+		r.ModifyPlan(ctx, resource.ModifyPlanRequest{Plan: plan}, resp)
+		return resp
+	}
+
+	data := plannedmodel(src)
+	data.ParentDirs = types.BoolValue(true)
+	require.False(t, modify(data).Diagnostics.HasError())
+
+	// the archive may only tighten the provider's limits.
+	data.Limits = &LimitsModel{
+		MaxEntrySize:        types.Int64Null(),
+		MaxTotalSize:        types.Int64Null(),
+		MaxEntries:          types.Int64Value(3),
+		MaxCompressionRatio: types.Float64Null(),
+	}
+	resp := modify(data)
+	require.True(t, resp.Diagnostics.HasError())
+	require.Contains(t, resp.Diagnostics[0].Detail(), "a/b/c/example.txt exceeds the maximum of 3 entries")
+}
+
+func TestArchiveResourceModifyPlanContentLimits(t *testing.T) {
+	r := &ArchiveResource{provider: &providerdata{}}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "large.bin"), make([]byte, 2048), 0600))
+
+	src := newsource()
+	src.Directory = types.StringValue(dir)
+	data := plannedmodel(src)
+	data.TimestampPolicy = types.StringValue(timestampContentDerived)
+	_, diags := modifyplan(t, r, data, nil)
+	require.False(t, diags.HasError(), "%v", diags)
+
+	// the limits are checked once against the content every planned attribute is derived from.
+	exceeded := func(data ArchiveResourceModel) {
+		_, diags := modifyplan(t, r, data, nil)
+		require.Len(t, diags, 1)
+		require.Contains(t, diags[0].Detail(), "large.bin is 2048 bytes exceeding the maximum entry size of 1024 bytes")
+	}
+
+	// the provider's limits apply once it is configured.
+	r.provider.limits = limits{entrysize: 1024}
+	exceeded(data)
+
+	// as do the archive's own limits.
+	r.provider.limits = limits{}
+	data.Limits = &LimitsModel{
+		MaxEntrySize:        types.Int64Value(1024),
+		MaxTotalSize:        types.Int64Null(),
+		MaxEntries:          types.Int64Null(),
+		MaxCompressionRatio: types.Float64Null(),
+	}
+	exceeded(data)
+
+	// known sources of a partially known archive are checked on their own.
+	unknown := newsource()
+	unknown.Base64 = types.StringUnknown()
+	unknown.Location = types.StringValue("unknown.txt")
+	data.Sources = append(data.Sources, unknown)
+	exceeded(data)
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"strings"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// ManifestModel describes a single entry within an archive.
+type ManifestModel struct {
+	Location types.String `tfsdk:"location"`
+	Size     types.Int64  `tfsdk:"size"`
+	Mode     types.Int32  `tfsdk:"mode"`
+	Type     types.String `tfsdk:"type"`
+	Sha256   types.String `tfsdk:"sha256"`
+}
+
+var manifestType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"location": types.StringType,
+		"size":     types.Int64Type,
+		"mode":     types.Int32Type,
+		"type":     types.StringType,
+		"sha256":   types.StringType,
+	},
+}
+
+// manifested describes the entry with the given header, the digest is only
+// recorded for files and links.
+func manifested(hdr *tar.Header, digest string) ManifestModel {
+	m := ManifestModel{
+		Location: types.StringValue(strings.TrimSuffix(hdr.Name, "/")),
+		Size:     types.Int64Value(hdr.Size),
+		Mode:     types.Int32Value(int32(hdr.Mode & 07777)),
+		Type:     types.StringValue(tarx.TypeName(hdr.Typeflag)),
+		Sha256:   types.StringNull(),
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+		m.Sha256 = types.StringValue(digest)
+	}
+
+	return m
+}
+
+func manifestvalue(manifest []ManifestModel) (types.List, error) {
+	l, diags := types.ListValueFrom(context.Background(), manifestType, manifest)
+	if diags.HasError() {
+		return types.ListNull(manifestType), errorsx.Errorf("unable to encode manifest: %v", diags)
+	}
+
+	return l, nil
+}
+
+// manifestof the archive the sources produce.
+func manifestof(ctx context.Context, data *ArchiveResourceModel) (_ types.List, err error) {
+	content, err := digest(ctx, data)
+	if err != nil {
+		return types.ListNull(manifestType), err
+	}
+
+	if content.manifest == nil {
+		return types.ListNull(manifestType), errorsx.Wrap(os.ErrNotExist, "unable to read every source")
+	}
+
+	return manifestvalue(content.manifest)
+}
@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveResourceManifest(t *testing.T) {
+	ctx := context.Background()
+	r := &ArchiveResource{}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "agent"), []byte("#!/bin/sh\n"), 0755))
+
+	config := newsource()
+	config.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("key: value\n")))
+	config.Location = types.StringValue("etc/agent.yaml")
+
+	binaries := newsource()
+	binaries.Directory = types.StringValue(dir)
+	binaries.Prefix = types.StringValue("usr/bin")
+
+	link := newsource()
+	link.Symlink = types.StringValue("usr/bin/agent")
+	link.Location = types.StringValue("agent")
+
+	data := plannedmodel(config, binaries, link)
+	data.Reproducible = types.BoolValue(true)
+	data.ParentDirs = types.BoolValue(true)
+
+	planned, diags := modifyplan(t, r, data, nil)
+	require.False(t, diags.HasError(), "%v", diags)
+
+	require.NoError(t, r.build(ctx, time.Unix(0, 0), &data, false))
+	require.Equal(t, data.Manifest, planned.Manifest)
+
+	var manifest []ManifestModel
+	require.False(t, data.Manifest.ElementsAs(ctx, &manifest, false).HasError())
+
+	summary := []string{}
+	for _, m := range manifest {
+		summary = append(summary, m.Type.ValueString()+" "+m.Location.ValueString())
+	}
+
+	require.Equal(t, []string{
+		"symlink agent",
+		"directory etc",
+		"file etc/agent.yaml",
+		"directory usr",
+		"directory usr/bin",
+		"file usr/bin/agent",
+	}, summary)
+	require.Equal(t, int32(0755), manifest[5].Mode.ValueInt32())
+	require.Equal(t, int64(10), manifest[5].Size.ValueInt64())
+	require.True(t, manifest[1].Sha256.IsNull())
+}
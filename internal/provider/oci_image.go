@@ -0,0 +1,750 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/ocix"
+	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int32default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// output formats of an image, named after the skopeo transports reading them.
+const (
+	imageLayout  = "oci"
+	imageArchive = "oci-archive"
+	imageDocker  = "docker-archive"
+)
+
+// OCIImageResourceModel describes an image assembled from layer archives.
+type OCIImageResourceModel struct {
+	Layers          []*OCIImageLayerModel `tfsdk:"layer"`
+	Entrypoint      types.List            `tfsdk:"entrypoint"`
+	Cmd             types.List            `tfsdk:"cmd"`
+	Env             types.Map             `tfsdk:"env"`
+	Labels          types.Map             `tfsdk:"labels"`
+	WorkingDir      types.String          `tfsdk:"working_dir"`
+	User            types.String          `tfsdk:"user"`
+	Platform        types.String          `tfsdk:"platform"`
+	Tag             types.String          `tfsdk:"tag"`
+	RepoTags        types.List            `tfsdk:"repo_tags"`
+	SourceDateEpoch types.Int64           `tfsdk:"source_date_epoch"`
+	OutputPath      types.String          `tfsdk:"output_path"`
+	OutputFormat    types.String          `tfsdk:"output_format"`
+	OutputFilePerm  types.Int32           `tfsdk:"output_file_permission"`
+	OutputDirPerm   types.Int32           `tfsdk:"output_directory_permission"`
+	ConfigDigest    types.String          `tfsdk:"config_digest"`
+	ManifestDigest  types.String          `tfsdk:"manifest_digest"`
+	IndexDigest     types.String          `tfsdk:"index_digest"`
+	ArchiveDigest   types.String          `tfsdk:"archive_digest"`
+}
+
+// OCIImageLayerModel describes a single layer of an image.
+type OCIImageLayerModel struct {
+	Path      types.String `tfsdk:"path"`
+	Base64    types.String `tfsdk:"base64"`
+	Digest    types.String `tfsdk:"digest"`
+	DiffID    types.String `tfsdk:"diff_id"`
+	Size      types.Int64  `tfsdk:"size"`
+	MediaType types.String `tfsdk:"media_type"`
+}
+
+// kind of content the layer is read from.
+func (t *OCIImageLayerModel) kind() string {
+	if !t.Path.IsNull() {
+		return "path"
+	}
+
+	return "base64"
+}
+
+// unknown reports if the content of the layer is unknown.
+func (t *OCIImageLayerModel) unknown() bool {
+	return t.Path.IsUnknown() || t.Base64.IsUnknown()
+}
+
+// open the content of the layer.
+func (t *OCIImageLayerModel) open() (io.ReadCloser, error) {
+	if !t.Path.IsNull() {
+		return os.Open(t.Path.ValueString())
+	}
+
+	return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(t.Base64.ValueString()))), nil
+}
+
+// describe the layer recording its digests.
+func (t *OCIImageLayerModel) describe() (desc ocix.Descriptor, err error) {
+	src, err := t.open()
+	if err != nil {
+		return desc, err
+	}
+	defer src.Close()
+
+	desc, diffid, err := ocix.Layer(src)
+	if err != nil {
+		return desc, err
+	}
+
+	t.Digest = basetypes.NewStringValue(desc.Digest)
+	t.DiffID = basetypes.NewStringValue(diffid)
+	t.Size = basetypes.NewInt64Value(desc.Size)
+	t.MediaType = basetypes.NewStringValue(desc.MediaType)
+	return desc, nil
+}
+
+// forget the digests of the layer, e.g. when its content is only known during apply.
+func (t *OCIImageLayerModel) forget() {
+	t.Digest = types.StringUnknown()
+	t.DiffID = types.StringUnknown()
+	t.Size = types.Int64Unknown()
+	t.MediaType = types.StringUnknown()
+}
+
+// forget the digests of the image.
+func (t *OCIImageResourceModel) forget() {
+	t.ConfigDigest = types.StringUnknown()
+	t.ManifestDigest = types.StringUnknown()
+	t.IndexDigest = types.StringUnknown()
+	t.ArchiveDigest = types.StringUnknown()
+}
+
+// image configuration of the model.
+func (t *OCIImageResourceModel) image(ctx context.Context, created time.Time, platform ocix.Platform, diffids []string) (img ocix.Image, diags diag.Diagnostics) {
+	var (
+		env = map[string]string{}
+	)
+
+	img = ocix.Image{
+		Created:      &created,
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		Variant:      platform.Variant,
+		Config: ocix.Config{
+			User:       t.User.ValueString(),
+			WorkingDir: t.WorkingDir.ValueString(),
+		},
+		RootFS: ocix.RootFS{Type: "layers", DiffIDs: diffids},
+	}
+
+	diags.Append(t.Entrypoint.ElementsAs(ctx, &img.Config.Entrypoint, false)...)
+	diags.Append(t.Cmd.ElementsAs(ctx, &img.Config.Cmd, false)...)
+	diags.Append(t.Labels.ElementsAs(ctx, &img.Config.Labels, false)...)
+	diags.Append(t.Env.ElementsAs(ctx, &env, false)...)
+
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		img.Config.Env = append(img.Config.Env, k+"="+env[k])
+	}
+
+	return img, diags
+}
+
+func NewOCIImageResource() resource.Resource {
+	return &OCIImageResource{}
+}
+
+// OCIImageResource assembles an OCI image from layer archives.
+type OCIImageResource struct{}
+
+func (r *OCIImageResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_oci_image"
+}
+
+func (r *OCIImageResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "assembles an OCI image from layer archives, e.g. eg_oci_layer or eg_tar, and writes it as an OCI image layout accepted by `podman load` and `skopeo copy` or as a docker archive accepted by `docker load`",
+		Blocks: map[string]schema.Block{
+			"layer": schema.ListNestedBlock{
+				MarkdownDescription: "layers of the image ordered from the base, uncompressed, gzip or zstd compressed tar archives",
+				Validators: []validator.List{
+					listvalidator.IsRequired(),
+					listvalidator.SizeAtLeast(1),
+				},
+				NestedObject: schema.NestedBlockObject{
+					Attributes: map[string]schema.Attribute{
+						"path": schema.StringAttribute{
+							MarkdownDescription: "path of the layer archive, e.g. the output_path of an eg_oci_layer. set digest and diff_id when the layer is produced by another resource",
+							Optional:            true,
+							Validators: []validator.String{
+								stringvalidator.ExactlyOneOf(
+									path.MatchRelative().AtParent().AtName("path"),
+									path.MatchRelative().AtParent().AtName("base64"),
+								),
+							},
+						},
+						"base64": schema.StringAttribute{
+							MarkdownDescription: "base64 encoded layer archive, e.g. the archiveb64 of an eg_oci_layer",
+							Optional:            true,
+						},
+						"digest": schema.StringAttribute{
+							MarkdownDescription: "sha256 digest of the layer blob as `sha256:<hex>`, e.g. the layer_digest of an eg_oci_layer. when set the layer is planned from the digests instead of its content, which may be stale until the resource producing it is applied. computed from the content when unset",
+							Optional:            true,
+							Computed:            true,
+							Validators: []validator.String{
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("diff_id")),
+							},
+						},
+						"diff_id": schema.StringAttribute{
+							MarkdownDescription: "sha256 digest of the uncompressed layer as `sha256:<hex>`, e.g. the diff_id of an eg_oci_layer. computed from the content when unset",
+							Optional:            true,
+							Computed:            true,
+							Validators: []validator.String{
+								stringvalidator.AlsoRequires(path.MatchRelative().AtParent().AtName("digest")),
+							},
+						},
+						"size": schema.Int64Attribute{
+							MarkdownDescription: "size of the layer blob in bytes",
+							Computed:            true,
+						},
+						"media_type": schema.StringAttribute{
+							MarkdownDescription: "media type of the layer as determined by its compression",
+							Computed:            true,
+						},
+					},
+				},
+			},
+		},
+		Attributes: map[string]schema.Attribute{
+			"entrypoint": schema.ListAttribute{
+				MarkdownDescription: "command executed by containers created from the image",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"cmd": schema.ListAttribute{
+				MarkdownDescription: "default arguments of the entrypoint",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"env": schema.MapAttribute{
+				MarkdownDescription: "environment variables of containers created from the image, recorded ordered by name",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"labels": schema.MapAttribute{
+				MarkdownDescription: "labels of the image",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"working_dir": schema.StringAttribute{
+				MarkdownDescription: "working directory of containers created from the image",
+				Optional:            true,
+			},
+			"user": schema.StringAttribute{
+				MarkdownDescription: "user, and optionally group, containers created from the image run as, e.g. 1000:1000",
+				Optional:            true,
+			},
+			"platform": schema.StringAttribute{
+				MarkdownDescription: "platform of the image as os/architecture[/variant], defaults to linux/amd64",
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString("linux/amd64"),
+			},
+			"tag": schema.StringAttribute{
+				MarkdownDescription: "name of the image within the layout, e.g. `latest` or `example.com/app:1.0`. podman names loaded images after it",
+				Optional:            true,
+			},
+			"repo_tags": schema.ListAttribute{
+				MarkdownDescription: "tags of the image within a docker-archive as repository:tag, e.g. `example.com/app:1.0`. docker tags loaded images with them",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"source_date_epoch": schema.Int64Attribute{
+				MarkdownDescription: "creation timestamp (unix seconds) of the image, defaults to the SOURCE_DATE_EPOCH environment variable or the unix epoch",
+				Optional:            true,
+			},
+			"output_path": schema.StringAttribute{
+				MarkdownDescription: "path the image is written to once complete, archives atomically replace the previous image while layout directories replace it by renaming it aside first. the resource is recreated when the image is removed or modified",
+				Required:            true,
+			},
+			"output_format": schema.StringAttribute{
+				MarkdownDescription: "format of the image written to output_path; `oci` for an image layout directory, `oci-archive` for a tarball of the layout or `docker-archive` for a tarball accepted by `docker load`. defaults to oci-archive",
+				Optional:            true,
+				Computed:            true,
+				Default:             stringdefault.StaticString(imageArchive),
+				Validators: []validator.String{
+					stringvalidator.OneOf(imageLayout, imageArchive, imageDocker),
+				},
+			},
+			"output_file_permission": schema.Int32Attribute{
+				MarkdownDescription: "permission bits of the files written to output_path, defaults to read/write for the user only",
+				Optional:            true,
+				Computed:            true,
+				Default:             int32default.StaticInt32(0600),
+			},
+			"output_directory_permission": schema.Int32Attribute{
+				MarkdownDescription: "permission bits of the directories created for output_path",
+				Optional:            true,
+				Computed:            true,
+				Default:             int32default.StaticInt32(0750),
+			},
+			"config_digest": schema.StringAttribute{
+				MarkdownDescription: "digest of the image config as `sha256:<hex>`, the image id",
+				Computed:            true,
+			},
+			"manifest_digest": schema.StringAttribute{
+				MarkdownDescription: "digest of the image manifest as `sha256:<hex>`, e.g. for referencing the image by digest once pushed",
+				Computed:            true,
+			},
+			"index_digest": schema.StringAttribute{
+				MarkdownDescription: "digest of the index.json of the layout as `sha256:<hex>`, null for docker-archive",
+				Computed:            true,
+			},
+			"archive_digest": schema.StringAttribute{
+				MarkdownDescription: "sha256 of the tarball written for oci-archive and docker-archive, e.g. matching `sha256sum` of output_path. null for the oci format",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (r *OCIImageResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var (
+		data OCIImageResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !data.Platform.IsNull() && !data.Platform.IsUnknown() {
+		if _, err := ocix.ParsePlatform(data.Platform.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("platform"), "invalid platform", err.Error())
+		}
+	}
+
+	validaterepotags(&data, &resp.Diagnostics)
+}
+
+// validaterepotags reports malformed repository tags and tags of images not written as docker archives.
+func validaterepotags(data *OCIImageResourceModel, diags *diag.Diagnostics) {
+	if data.RepoTags.IsNull() || data.RepoTags.IsUnknown() {
+		return
+	}
+
+	if format := data.OutputFormat.ValueString(); !data.OutputFormat.IsUnknown() && format != imageDocker {
+		diags.AddAttributeError(path.Root("repo_tags"), "invalid repository tags", fmt.Sprintf("repo_tags requires the docker-archive output format, got %s. use tag to name images within OCI layouts", format))
+		return
+	}
+
+	for i, v := range data.RepoTags.Elements() {
+		tag, ok := v.(types.String)
+		if !ok || tag.IsUnknown() {
+			continue
+		}
+
+		if _, _, err := ocix.ParseRepoTag(tag.ValueString()); err != nil {
+			diags.AddAttributeError(path.Root("repo_tags").AtListIndex(i), "invalid repository tag", err.Error())
+		}
+	}
+}
+
+// ModifyPlan describes the layers known during planning so changes to their
+// content are planned, the digests of the image are recomputed when they change.
+// layers whose digests are configured are planned from them, their content may
+// be replaced during apply by the resource producing it.
+func (r *OCIImageResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	var (
+		data       OCIImageResourceModel
+		configured []*OCIImageLayerModel
+		prior      []*OCIImageLayerModel
+	)
+
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("layer"), &configured)...)
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("layer"), &prior)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	changed := len(prior) != len(data.Layers)
+	for i, l := range data.Layers {
+		var (
+			c = newimagelayerconfig()
+			p *OCIImageLayerModel
+		)
+
+		if i < len(configured) {
+			c = configured[i]
+		}
+
+		if i < len(prior) {
+			p = prior[i]
+		}
+
+		switch {
+		case l.unknown() || c.Digest.IsUnknown() || c.DiffID.IsUnknown():
+			// the layer is produced during apply, e.g. its eg_oci_layer is replaced.
+			l.forget()
+		case !c.Digest.IsNull():
+			l.Digest, l.DiffID = c.Digest, c.DiffID
+			if p != nil && p.Digest.Equal(l.Digest) && p.DiffID.Equal(l.DiffID) {
+				l.Size, l.MediaType = p.Size, p.MediaType
+			} else {
+				l.Size, l.MediaType = types.Int64Unknown(), types.StringUnknown()
+			}
+		case p != nil && !l.Path.IsNull() && !l.Path.Equal(p.Path):
+			// the layer at the new path may be written during apply.
+			l.forget()
+		default:
+			if _, err := l.describe(); errors.Is(err, os.ErrNotExist) {
+				// the layer may be produced during apply.
+				l.forget()
+			} else if err != nil {
+				resp.Diagnostics.Append(errdiag("invalid layer", attributed(path.Root("layer").AtListIndex(i).AtName(l.kind()), errorsx.UserFriendly(err))))
+				return
+			}
+		}
+
+		changed = changed || p == nil || !l.Digest.Equal(p.Digest) || !l.DiffID.Equal(p.DiffID)
+	}
+
+	if changed {
+		data.forget()
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
+}
+
+// newimagelayerconfig of a layer without configured digests.
+func newimagelayerconfig() *OCIImageLayerModel {
+	return &OCIImageLayerModel{Digest: types.StringNull(), DiffID: types.StringNull()}
+}
+
+// build the image writing it to the output path.
+func (r *OCIImageResource) build(ctx context.Context, data *OCIImageResourceModel) (err error) {
+	var (
+		diffids = make([]string, 0, len(data.Layers))
+		layers  = make([]ocix.Descriptor, 0, len(data.Layers))
+		blobs   = make([]ocix.Blob, 0, len(data.Layers)+2)
+	)
+
+	platform, err := ocix.ParsePlatform(data.Platform.ValueString())
+	if err != nil {
+		return attributed(path.Root("platform"), err)
+	}
+
+	created, err := sourcedateepoch(data.SourceDateEpoch)
+	if err != nil {
+		return attributed(path.Root("source_date_epoch"), err)
+	}
+
+	for i, l := range data.Layers {
+		planned := *l
+		desc, err := l.describe()
+		if err != nil {
+			return attributed(path.Root("layer").AtListIndex(i).AtName(l.kind()), errorsx.UserFriendly(errorsx.Wrap(err, "unable to read layer")))
+		}
+
+		if !planned.Digest.IsUnknown() && !planned.Digest.Equal(l.Digest) || !planned.DiffID.IsUnknown() && !planned.DiffID.Equal(l.DiffID) {
+			return attributed(path.Root("layer").AtListIndex(i).AtName(l.kind()), errorsx.UserFriendly(errorsx.Errorf("layer %s (diff_id %s) does not match the planned digest %s (diff_id %s)", l.Digest.ValueString(), l.DiffID.ValueString(), planned.Digest.ValueString(), planned.DiffID.ValueString())))
+		}
+
+		layers = append(layers, desc)
+		diffids = append(diffids, l.DiffID.ValueString())
+		blobs = append(blobs, ocix.Blob{Descriptor: desc, Open: l.open})
+	}
+
+	img, diags := data.image(ctx, created, platform, diffids)
+	if diags.HasError() {
+		return errorsx.Errorf("%s: %s", diags[0].Summary(), diags[0].Detail())
+	}
+
+	cdesc, config, err := ocix.Marshal(ocix.MediaTypeConfig, img)
+	if err != nil {
+		return err
+	}
+
+	mdesc, manifest, err := ocix.Marshal(ocix.MediaTypeManifest, ocix.Manifest{
+		SchemaVersion: 2,
+		MediaType:     ocix.MediaTypeManifest,
+		Config:        cdesc,
+		Layers:        layers,
+	})
+	if err != nil {
+		return err
+	}
+
+	blobs = append(blobs, ocix.BytesBlob(cdesc, config), ocix.BytesBlob(mdesc, manifest))
+	mdesc.Platform = &platform
+	if !data.Tag.IsNull() {
+		mdesc.Annotations = map[string]string{ocix.AnnotationRefName: data.Tag.ValueString()}
+	}
+
+	data.ConfigDigest = basetypes.NewStringValue(cdesc.Digest)
+	data.ManifestDigest = basetypes.NewStringValue(mdesc.Digest)
+
+	write := func(w ocix.Writer) error {
+		index, err := ocix.WriteLayout(w, []ocix.Descriptor{mdesc}, blobs...)
+		if err != nil {
+			return err
+		}
+
+		data.IndexDigest = basetypes.NewStringValue(index.Digest)
+		return w.Close()
+	}
+
+	switch data.OutputFormat.ValueString() {
+	case imageLayout:
+		data.ArchiveDigest = basetypes.NewStringNull()
+		return r.writelayout(data, write)
+	case imageDocker:
+		tags := []string{}
+		if diags = data.RepoTags.ElementsAs(ctx, &tags, false); diags.HasError() {
+			return errorsx.Errorf("%s: %s", diags[0].Summary(), diags[0].Detail())
+		}
+
+		for i, tag := range tags {
+			if _, _, err = ocix.ParseRepoTag(tag); err != nil {
+				return attributed(path.Root("repo_tags").AtListIndex(i), err)
+			}
+		}
+
+		data.IndexDigest = basetypes.NewStringNull()
+		return r.writearchive(data, created, func(w ocix.Writer) error {
+			if err := ocix.WriteDockerArchive(w, cdesc, layers, tags, blobs...); err != nil {
+				return err
+			}
+
+			return w.Close()
+		})
+	default:
+		return r.writearchive(data, created, write)
+	}
+}
+
+// writelayout writes the layout directory to the output path, replacing the previous image once it is complete.
+func (r *OCIImageResource) writelayout(data *OCIImageResourceModel, write func(ocix.Writer) error) (err error) {
+	output := data.OutputPath.ValueString()
+	dirperm := fs.FileMode(data.OutputDirPerm.ValueInt32())
+	if err = os.MkdirAll(filepath.Dir(output), dirperm); err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrapf(err, "failed to create output directory %s", filepath.Dir(output)))
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(output), ".egt.image.*")
+	if err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrap(err, "failed to create temporary layout"))
+	}
+	defer os.RemoveAll(tmp)
+
+	if err = write(ocix.NewDirWriter(tmp, fs.FileMode(data.OutputFilePerm.ValueInt32()), dirperm)); err != nil {
+		return attributed(path.Root("output_path"), err)
+	}
+
+	if err = os.Chmod(tmp, dirperm); err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrap(err, "failed to finalize layout"))
+	}
+
+	return attributed(path.Root("output_path"), errorsx.Wrapf(replaceimage(tmp, output), "failed to write image to %s", output))
+}
+
+// writearchive atomically writes the tarball of the layout to the output path.
+func (r *OCIImageResource) writearchive(data *OCIImageResourceModel, mtime time.Time, write func(ocix.Writer) error) (err error) {
+	var (
+		digest = sha256.New()
+		output = data.OutputPath.ValueString()
+	)
+
+	if err = os.MkdirAll(filepath.Dir(output), fs.FileMode(data.OutputDirPerm.ValueInt32())); err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrapf(err, "failed to create output directory %s", filepath.Dir(output)))
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(output), ".egt.image.*")
+	if err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrap(err, "failed to create temporary archive"))
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+
+	if err = write(ocix.NewTarWriter(io.MultiWriter(dst, digest), mtime)); err != nil {
+		return attributed(path.Root("output_path"), err)
+	}
+
+	if err = errorsx.Compact(dst.Chmod(fs.FileMode(data.OutputFilePerm.ValueInt32())), dst.Sync(), dst.Close()); err != nil {
+		return attributed(path.Root("output_path"), errorsx.Wrap(err, "failed to finalize archive"))
+	}
+
+	data.ArchiveDigest = basetypes.NewStringValue(hex.EncodeToString(digest.Sum(nil)))
+	return attributed(path.Root("output_path"), errorsx.Wrapf(replaceimage(dst.Name(), output), "failed to write image to %s", output))
+}
+
+// drifted reports if the image written to the output path was removed or modified.
+func (r *OCIImageResource) drifted(data *OCIImageResourceModel) (string, error) {
+	var (
+		output  = data.OutputPath.ValueString()
+		current string
+		err     error
+		digest  = data.ArchiveDigest.ValueString()
+	)
+
+	switch data.OutputFormat.ValueString() {
+	case imageLayout:
+		digest = data.IndexDigest.ValueString()
+		if current, err = iox.Sha256(filepath.Join(output, "index.json")); err == nil {
+			current = "sha256:" + current
+		}
+	default:
+		current, err = iox.Sha256(output)
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Sprintf("image %s is missing", output), nil
+	} else if err != nil {
+		return "", attributed(path.Root("output_path"), err)
+	}
+
+	if current != digest {
+		return fmt.Sprintf("image %s was modified", output), nil
+	}
+
+	return "", nil
+}
+
+// replaceimage renames the staged image to the output path. archives replace
+// archives atomically, otherwise the previous image can not be renamed over so
+// it is moved aside until the staged image is in place and restored when that
+// fails, a failed write leaves the previous image in place.
+func replaceimage(staged, output string) error {
+	info, err := os.Lstat(output)
+	if err != nil {
+		return os.Rename(staged, output)
+	}
+
+	sinfo, err := os.Lstat(staged)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() && !sinfo.IsDir() {
+		return os.Rename(staged, output)
+	}
+
+	previous := staged + ".previous"
+	if err = os.Rename(output, previous); err != nil {
+		return err
+	}
+
+	if err = os.Rename(staged, output); err != nil {
+		return errorsx.Compact(err, os.Rename(previous, output))
+	}
+
+	errorsx.Log(errorsx.Wrap(os.RemoveAll(previous), "failed to remove previous image"))
+	return nil
+}
+
+// removeimage removes the image written in the format.
+func removeimage(format, p string) error {
+	if format == imageLayout {
+		return os.RemoveAll(p)
+	}
+
+	return remove(p)
+}
+
+func (r *OCIImageResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var (
+		data OCIImageResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.build(ctx, &data); err != nil {
+		resp.Diagnostics.Append(errdiag("failed to generate image", err))
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *OCIImageResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var (
+		data OCIImageResourceModel
+	)
+
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	reason, err := r.drifted(&data)
+	if err != nil {
+		resp.Diagnostics.Append(errdiag("failed to verify image", err))
+		return
+	}
+
+	if reason != "" {
+		tflog.Info(ctx, fmt.Sprintf("%s, recreating", reason))
+		resp.State.RemoveResource(ctx)
+	}
+}
+
+func (r *OCIImageResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var (
+		data  OCIImageResourceModel
+		prior OCIImageResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := r.build(ctx, &data); err != nil {
+		resp.Diagnostics.Append(errdiag("failed to generate image", err))
+		return
+	}
+
+	if prior.OutputPath.ValueString() != data.OutputPath.ValueString() {
+		errorsx.Log(errorsx.Wrap(removeimage(prior.OutputFormat.ValueString(), prior.OutputPath.ValueString()), "failed to remove previous image"))
+	}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *OCIImageResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var (
+		data OCIImageResourceModel
+	)
+
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if err := removeimage(data.OutputFormat.ValueString(), data.OutputPath.ValueString()); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("output_path"), "failed to remove image", err.Error())
+	}
+}
@@ -0,0 +1,394 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/ocix"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func newimagelayer() *OCIImageLayerModel {
+	return &OCIImageLayerModel{
+		Path:      types.StringNull(),
+		Base64:    types.StringNull(),
+		Digest:    types.StringUnknown(),
+		DiffID:    types.StringUnknown(),
+		Size:      types.Int64Unknown(),
+		MediaType: types.StringUnknown(),
+	}
+}
+
+func plannedimage(output string, layers ...*OCIImageLayerModel) OCIImageResourceModel {
+	return OCIImageResourceModel{
+		Layers:          layers,
+		Entrypoint:      types.ListNull(types.StringType),
+		Cmd:             types.ListNull(types.StringType),
+		Env:             types.MapNull(types.StringType),
+		Labels:          types.MapNull(types.StringType),
+		WorkingDir:      types.StringNull(),
+		User:            types.StringNull(),
+		Platform:        types.StringValue("linux/amd64"),
+		Tag:             types.StringNull(),
+		RepoTags:        types.ListNull(types.StringType),
+		SourceDateEpoch: types.Int64Value(1700000000),
+		OutputPath:      types.StringValue(output),
+		OutputFormat:    types.StringValue(imageArchive),
+		OutputFilePerm:  types.Int32Value(0600),
+		OutputDirPerm:   types.Int32Value(0750),
+		ConfigDigest:    types.StringUnknown(),
+		ManifestDigest:  types.StringUnknown(),
+		IndexDigest:     types.StringUnknown(),
+		ArchiveDigest:   types.StringUnknown(),
+	}
+}
+
+// layerarchive builds an archive of the format containing a single file.
+func layerarchive(t *testing.T, f *format, compression tarx.Codec, location, contents string) ArchiveResourceModel {
+	src := newsource()
+	src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(contents)))
+	src.Location = types.StringValue(location)
+
+	data := plannedmodel(src)
+	data.Compression = types.StringValue(string(compression))
+	data.Reproducible = types.BoolValue(true)
+	data.SourceDateEpoch = types.Int64Value(1700000000)
+	ts, err := sourcedateepoch(data.SourceDateEpoch)
+	require.NoError(t, err)
+	require.NoError(t, (&ArchiveResource{format: f}).build(context.Background(), ts, &data, false))
+	return data
+}
+
+func TestOCIImageResourceBuild(t *testing.T) {
+	ctx := context.Background()
+	r := &OCIImageResource{}
+	dir := t.TempDir()
+
+	base := layerarchive(t, formatocilayer, tarx.CodecGzip, "usr/bin/agent", "#!/bin/sh\n")
+	config := layerarchive(t, formattar, tarx.CodecNone, "etc/agent.conf", "verbose = true\n")
+	decoded, err := base64.StdEncoding.DecodeString(config.ArchiveB64.ValueString())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.tar"), decoded, 0600))
+
+	first := newimagelayer()
+	first.Base64 = base.ArchiveB64
+	second := newimagelayer()
+	second.Path = types.StringValue(filepath.Join(dir, "config.tar"))
+
+	data := plannedimage(filepath.Join(dir, "image.tar"), first, second)
+	data.Entrypoint = types.ListValueMust(types.StringType, []attr.Value{types.StringValue("/usr/bin/agent")})
+	data.Env = types.MapValueMust(types.StringType, map[string]attr.Value{"PATH": types.StringValue("/usr/bin"), "HOME": types.StringValue("/root")})
+	data.Labels = types.MapValueMust(types.StringType, map[string]attr.Value{"org.opencontainers.image.source": types.StringValue("https://example.com/agent")})
+	data.Platform = types.StringValue("linux/arm64/v8")
+	data.Tag = types.StringValue("example.com/agent:1.0")
+	require.NoError(t, r.build(ctx, &data))
+
+	// layers produced by eg_oci_layer are described identically by the image.
+	require.Equal(t, first.Digest.ValueString(), "sha256:"+base.ArchiveDigest.ValueString())
+	require.Equal(t, ocix.MediaTypeLayer+"+gzip", first.MediaType.ValueString())
+	require.Equal(t, ocix.MediaTypeLayer, second.MediaType.ValueString())
+
+	archived, err := os.ReadFile(data.OutputPath.ValueString())
+	require.NoError(t, err)
+	current, err := iox.Sha256(data.OutputPath.ValueString())
+	require.NoError(t, err)
+	require.Equal(t, current, data.ArchiveDigest.ValueString())
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(archived))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.True(t, time.Unix(1700000000, 0).Equal(hdr.ModTime))
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = contents
+	}
+
+	blob := func(digest string, v any) []byte {
+		desc := ocix.Descriptor{Digest: digest}
+		encoded, ok := files[ocix.BlobPath(desc)]
+		require.True(t, ok, digest)
+		actual := sha256.Sum256(encoded)
+		require.Equal(t, digest, "sha256:"+hex.EncodeToString(actual[:]))
+		if v != nil {
+			require.NoError(t, json.Unmarshal(encoded, v))
+		}
+		return encoded
+	}
+
+	require.JSONEq(t, `{"imageLayoutVersion":"1.0.0"}`, string(files["oci-layout"]))
+
+	index := ocix.Index{}
+	require.NoError(t, json.Unmarshal(files["index.json"], &index))
+	require.Len(t, index.Manifests, 1)
+	require.Equal(t, data.ManifestDigest.ValueString(), index.Manifests[0].Digest)
+	require.Equal(t, &ocix.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, index.Manifests[0].Platform)
+	require.Equal(t, map[string]string{ocix.AnnotationRefName: "example.com/agent:1.0"}, index.Manifests[0].Annotations)
+
+	manifest := ocix.Manifest{}
+	blob(data.ManifestDigest.ValueString(), &manifest)
+	require.Equal(t, data.ConfigDigest.ValueString(), manifest.Config.Digest)
+	require.Equal(t, ocix.MediaTypeConfig, manifest.Config.MediaType)
+	require.Len(t, manifest.Layers, 2)
+
+	img := ocix.Image{}
+	blob(data.ConfigDigest.ValueString(), &img)
+	require.Equal(t, []string{first.DiffID.ValueString(), second.DiffID.ValueString()}, img.RootFS.DiffIDs)
+	require.Equal(t, []string{"HOME=/root", "PATH=/usr/bin"}, img.Config.Env)
+	require.Equal(t, []string{"/usr/bin/agent"}, img.Config.Entrypoint)
+	require.Equal(t, "arm64", img.Architecture)
+	require.True(t, time.Unix(1700000000, 0).Equal(*img.Created))
+
+	for i, l := range []*OCIImageLayerModel{first, second} {
+		require.Equal(t, l.Digest.ValueString(), manifest.Layers[i].Digest)
+		require.Equal(t, l.Size.ValueInt64(), int64(len(blob(l.Digest.ValueString(), nil))))
+	}
+
+	reason, err := r.drifted(&data)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+
+	// the layout directory contains the same documents.
+	layout := data
+	layout.OutputPath = types.StringValue(filepath.Join(dir, "image"))
+	layout.OutputFormat = types.StringValue(imageLayout)
+	require.NoError(t, r.build(ctx, &layout))
+	require.Equal(t, data.IndexDigest, layout.IndexDigest)
+	require.True(t, layout.ArchiveDigest.IsNull())
+
+	encoded, err := os.ReadFile(filepath.Join(dir, "image", "index.json"))
+	require.NoError(t, err)
+	require.Equal(t, files["index.json"], encoded)
+
+	reason, err = r.drifted(&layout)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "image", "index.json"), []byte("{}"), 0600))
+	reason, err = r.drifted(&layout)
+	require.NoError(t, err)
+	require.Contains(t, reason, "was modified")
+}
+
+func TestOCIImageResourceModifyPlan(t *testing.T) {
+	ctx := context.Background()
+	r := &OCIImageResource{}
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+	dir := t.TempDir()
+
+	config := layerarchive(t, formattar, tarx.CodecGzip, "etc/agent.conf", "verbose = true\n")
+	decoded, err := base64.StdEncoding.DecodeString(config.ArchiveB64.ValueString())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.tar.gz"), decoded, 0600))
+
+	existing := newimagelayer()
+	existing.Path = types.StringValue(filepath.Join(dir, "config.tar.gz"))
+	pending := newimagelayer()
+	pending.Path = types.StringValue(filepath.Join(dir, "pending.tar.gz"))
+
+	plan := tfsdk.Plan{Schema: schemaresp.Schema}
+	require.False(t, plan.Set(ctx, plannedimage(filepath.Join(dir, "image.tar"), existing, pending)).HasError())
+	resp := &resource.ModifyPlanResponse{Plan: plan}
+	r.ModifyPlan(ctx, resource.ModifyPlanRequest{Config: imageconfig(t, plan), Plan: plan}, resp)
+	require.False(t, resp.Diagnostics.HasError(), "%v", resp.Diagnostics)
+
+	planned := OCIImageResourceModel{}
+	require.False(t, resp.Plan.Get(ctx, &planned).HasError())
+	require.Equal(t, types.StringValue("sha256:"+config.ArchiveDigest.ValueString()), planned.Layers[0].Digest)
+	require.Equal(t, types.StringValue(ocix.MediaTypeLayer+"+gzip"), planned.Layers[0].MediaType)
+	require.True(t, planned.Layers[1].Digest.IsUnknown())
+	require.True(t, planned.ConfigDigest.IsUnknown())
+}
+
+// imageconfig of the planned image, the computed attributes are unset.
+func imageconfig(t *testing.T, plan tfsdk.Plan, digests ...*OCIImageLayerModel) tfsdk.Config {
+	var (
+		data OCIImageResourceModel
+	)
+
+	ctx := context.Background()
+	require.False(t, plan.Get(ctx, &data).HasError())
+	for i, l := range data.Layers {
+		l.Digest, l.DiffID = types.StringNull(), types.StringNull()
+		if i < len(digests) && digests[i] != nil {
+			l.Digest, l.DiffID = digests[i].Digest, digests[i].DiffID
+		}
+		l.Size, l.MediaType = types.Int64Null(), types.StringNull()
+	}
+	data.ConfigDigest, data.ManifestDigest = types.StringNull(), types.StringNull()
+	data.IndexDigest, data.ArchiveDigest = types.StringNull(), types.StringNull()
+
+	config := tfsdk.Plan{Schema: plan.Schema}
+	require.False(t, config.Set(ctx, &data).HasError())
+	return tfsdk.Config{Schema: plan.Schema, Raw: config.Raw}
+}
+
+func TestOCIImageResourceModifyPlanDigests(t *testing.T) {
+	ctx := context.Background()
+	r := &OCIImageResource{}
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+	dir := t.TempDir()
+	output := filepath.Join(dir, "layer.tar.gz")
+
+	write := func(contents string) ArchiveResourceModel {
+		layer := layerarchive(t, formatocilayer, tarx.CodecGzip, "usr/bin/agent", contents)
+		decoded, err := base64.StdEncoding.DecodeString(layer.ArchiveB64.ValueString())
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(output, decoded, 0600))
+		return layer
+	}
+
+	// upstream describes the layer as the eg_oci_layer producing it does.
+	upstream := func(digest, diffid types.String) *OCIImageLayerModel {
+		l := newimagelayer()
+		l.Digest, l.DiffID = digest, diffid
+		return l
+	}
+
+	applied := write("#!/bin/sh\n")
+	layer := newimagelayer()
+	layer.Path = types.StringValue(output)
+	state := plannedimage(filepath.Join(dir, "image.tar"), layer)
+	require.NoError(t, r.build(ctx, &state))
+	prior := tfsdk.State{Schema: schemaresp.Schema}
+	require.False(t, prior.Set(ctx, &state).HasError())
+	described := *layer
+
+	modify := func(digests *OCIImageLayerModel) OCIImageResourceModel {
+		resp := &resource.ModifyPlanResponse{Plan: tfsdk.Plan{Schema: schemaresp.Schema, Raw: prior.Raw}}
+		r.ModifyPlan(ctx, resource.ModifyPlanRequest{Config: imageconfig(t, resp.Plan, digests), Plan: resp.Plan, State: prior}, resp)
+		require.False(t, resp.Diagnostics.HasError(), "%v", resp.Diagnostics)
+
+		planned := OCIImageResourceModel{}
+		require.False(t, resp.Plan.Get(ctx, &planned).HasError())
+		return planned
+	}
+
+	// unchanged digests plan no changes.
+	planned := modify(upstream(described.Digest, described.DiffID))
+	require.Equal(t, described, *planned.Layers[0])
+	require.Equal(t, state.ArchiveDigest, planned.ArchiveDigest)
+
+	// while the eg_oci_layer is replaced its digests are unknown, the stale layer on disk is not consulted.
+	planned = modify(upstream(types.StringUnknown(), types.StringUnknown()))
+	require.True(t, planned.Layers[0].Digest.IsUnknown())
+	require.True(t, planned.Layers[0].DiffID.IsUnknown())
+	require.True(t, planned.ArchiveDigest.IsUnknown())
+
+	// known digests of the replacement are planned without reading the layer.
+	replaced := layerarchive(t, formatocilayer, tarx.CodecGzip, "usr/bin/agent", "#!/bin/bash\n")
+	digest := types.StringValue("sha256:" + replaced.ArchiveDigest.ValueString())
+	planned = modify(upstream(digest, described.DiffID))
+	require.Equal(t, digest, planned.Layers[0].Digest)
+	require.True(t, planned.Layers[0].Size.IsUnknown())
+	require.True(t, planned.ConfigDigest.IsUnknown())
+
+	// the layer must match the planned digests once applied.
+	require.Equal(t, applied.ArchiveDigest, write("#!/bin/sh\n").ArchiveDigest)
+	require.ErrorContains(t, r.build(ctx, &planned), "does not match the planned digest")
+}
+
+func TestOCIImageResourceOutputFormatChange(t *testing.T) {
+	ctx := context.Background()
+	r := &OCIImageResource{}
+	output := filepath.Join(t.TempDir(), "image")
+
+	base := layerarchive(t, formatocilayer, tarx.CodecGzip, "usr/bin/agent", "#!/bin/sh\n")
+	for _, format := range []string{imageLayout, imageDocker, imageLayout, imageArchive} {
+		layer := newimagelayer()
+		layer.Base64 = base.ArchiveB64
+		data := plannedimage(output, layer)
+		data.OutputFormat = types.StringValue(format)
+		require.NoError(t, r.build(ctx, &data), format)
+
+		reason, err := r.drifted(&data)
+		require.NoError(t, err)
+		require.Empty(t, reason, format)
+	}
+}
+
+func TestOCIImageResourceDockerArchive(t *testing.T) {
+	ctx := context.Background()
+	r := &OCIImageResource{}
+	dir := t.TempDir()
+
+	base := layerarchive(t, formatocilayer, tarx.CodecGzip, "usr/bin/agent", "#!/bin/sh\n")
+	layer := newimagelayer()
+	layer.Base64 = base.ArchiveB64
+
+	data := plannedimage(filepath.Join(dir, "image.tar"), layer)
+	data.OutputFormat = types.StringValue(imageDocker)
+	data.RepoTags = types.ListValueMust(types.StringType, []attr.Value{types.StringValue("example.com/agent:1.0")})
+	require.NoError(t, r.build(ctx, &data))
+	require.True(t, data.IndexDigest.IsNull())
+
+	current, err := iox.Sha256(data.OutputPath.ValueString())
+	require.NoError(t, err)
+	require.Equal(t, current, data.ArchiveDigest.ValueString())
+
+	archived, err := os.ReadFile(data.OutputPath.ValueString())
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(archived))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = contents
+	}
+
+	manifests := []ocix.DockerManifest{}
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifests))
+	require.Equal(t, []ocix.DockerManifest{{
+		Config:   ocix.BlobPath(ocix.Descriptor{Digest: data.ConfigDigest.ValueString()}),
+		RepoTags: []string{"example.com/agent:1.0"},
+		Layers:   []string{ocix.BlobPath(ocix.Descriptor{Digest: layer.Digest.ValueString()})},
+	}}, manifests)
+	require.Contains(t, files, "repositories")
+	require.NotContains(t, files, "index.json")
+
+	reason, err := r.drifted(&data)
+	require.NoError(t, err)
+	require.Empty(t, reason)
+
+	// repository tags must include the tag and are only written to docker archives.
+	diags := diag.Diagnostics{}
+	data.RepoTags = types.ListValueMust(types.StringType, []attr.Value{types.StringValue("example.com:5000/agent")})
+	validaterepotags(&data, &diags)
+	require.True(t, diags.HasError())
+	require.Equal(t, path.Root("repo_tags").AtListIndex(0), diags[0].(diag.DiagnosticWithPath).Path())
+
+	diags = diag.Diagnostics{}
+	data.OutputFormat = types.StringValue(imageArchive)
+	validaterepotags(&data, &diags)
+	require.True(t, diags.HasError())
+	require.Equal(t, path.Root("repo_tags"), diags[0].(diag.DiagnosticWithPath).Path())
+}
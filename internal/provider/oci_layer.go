@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"context"
+	pathpkg "path"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// OCILayerResourceModel describes an OCI image layer, an archive along with
+// the digests identifying it within an image.
+type OCILayerResourceModel struct {
+	ArchiveResourceModel
+	DiffID      types.String `tfsdk:"diff_id"`
+	LayerDigest types.String `tfsdk:"layer_digest"`
+	MediaType   types.String `tfsdk:"media_type"`
+}
+
+// layered records the digests of the generated layer.
+func (t *OCILayerResourceModel) layered(digests *layerdigests) {
+	t.DiffID = basetypes.NewStringValue(digests.diffid)
+	t.LayerDigest = basetypes.NewStringValue(digests.digest)
+	t.MediaType = t.Mimetype
+}
+
+func NewOCILayerResource() resource.Resource {
+	return &OCILayerResource{ArchiveResource: ArchiveResource{format: formatocilayer}}
+}
+
+// OCILayerResource generates OCI image layers from the sources of an archive.
+type OCILayerResource struct {
+	ArchiveResource
+}
+
+// layerer of the layer, an archive resource recording the digests of the layer it writes.
+func (r *OCILayerResource) layerer(digests *layerdigests) *ArchiveResource {
+	layerer := r.ArchiveResource
+	layerer.format = formatlayer(digests)
+	return &layerer
+}
+
+func (r *OCILayerResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	r.ArchiveResource.Schema(ctx, req, resp)
+	resp.Schema.Attributes["diff_id"] = schema.StringAttribute{
+		MarkdownDescription: "sha256 digest of the uncompressed layer as `sha256:<hex>`, as listed by rootfs.diff_ids of an image config",
+		Computed:            true,
+	}
+	resp.Schema.Attributes["layer_digest"] = schema.StringAttribute{
+		MarkdownDescription: "sha256 digest of the compressed layer blob as `sha256:<hex>`, identifying the layer within image manifests. the size of the blob is archive_size",
+		Computed:            true,
+	}
+	resp.Schema.Attributes["media_type"] = schema.StringAttribute{
+		MarkdownDescription: "OCI media type of the layer as determined by the compression codec, e.g. application/vnd.oci.image.layer.v1.tar+gzip",
+		Computed:            true,
+	}
+}
+
+func (r *OCILayerResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var (
+		data OCILayerResourceModel
+	)
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.validate(ctx, &data.ArchiveResourceModel, &resp.Diagnostics)
+	validatelayer(&data.ArchiveResourceModel, &resp.Diagnostics)
+}
+
+// validatelayer reports sources violating the conventions of OCI layers; owner
+// names are not consulted by container runtimes and whiteout names are reserved
+// for whiteout and opaque sources.
+func validatelayer(data *ArchiveResourceModel, diags *diag.Diagnostics) {
+	for i, v := range data.Sources {
+		p := path.Root("source").AtListIndex(i)
+		for name, owner := range map[string]types.String{"uname": v.Uname, "gname": v.Gname} {
+			if !owner.IsNull() {
+				diags.AddAttributeError(p.AtName(name), "invalid ownership", "OCI layers record numeric ownership only, use uid and gid")
+			}
+		}
+
+		if kind := v.kind(); kind == "whiteout" || kind == "opaque" || v.Location.IsUnknown() {
+			continue
+		}
+
+		if strings.HasPrefix(pathpkg.Base(v.Location.ValueString()), whiteoutprefix) {
+			diags.AddAttributeError(p.AtName("location"), "invalid location", "names prefixed with "+whiteoutprefix+" are reserved for whiteout and opaque sources within OCI layers")
+		}
+	}
+}
+
+func (r *OCILayerResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	var (
+		data  OCILayerResourceModel
+		state *OCILayerResourceModel
+		prior *ArchiveResourceModel
+	)
+
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if state != nil {
+		prior = &state.ArchiveResourceModel
+	}
+
+	if r.plan(ctx, &data.ArchiveResourceModel, prior, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
+}
+
+func (r *OCILayerResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var (
+		data    OCILayerResourceModel
+		digests layerdigests
+	)
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.layerer(&digests).create(ctx, &data.ArchiveResourceModel, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.layered(&digests)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *OCILayerResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var (
+		data OCILayerResourceModel
+	)
+
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !r.exists(ctx, &data.ArchiveResourceModel, &resp.Diagnostics) {
+		resp.State.RemoveResource(ctx)
+	}
+}
+
+func (r *OCILayerResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var (
+		data    OCILayerResourceModel
+		prior   OCILayerResourceModel
+		digests layerdigests
+	)
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &prior)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if r.layerer(&digests).update(ctx, &data.ArchiveResourceModel, &prior.ArchiveResourceModel, &resp.Diagnostics); resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.layered(&digests)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *OCILayerResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var (
+		data OCILayerResourceModel
+	)
+
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.delete(&data.ArchiveResourceModel, &resp.Diagnostics)
+}
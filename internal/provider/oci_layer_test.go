@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCILayerResourceCreate(t *testing.T) {
+	ctx := context.Background()
+	r := NewOCILayerResource().(*OCILayerResource)
+	schemaresp := &resource.SchemaResponse{}
+	r.Schema(ctx, resource.SchemaRequest{}, schemaresp)
+
+	binary := newsource()
+	binary.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n")))
+	binary.Location = types.StringValue("usr/bin/agent")
+	binary.Perm = types.Int32Value(0755)
+	binary.Uid = types.Int64Value(1000)
+	binary.Uname = types.StringValue("agent")
+
+	removed := newsource()
+	removed.Whiteout = types.StringValue("etc/agent.conf")
+
+	cleared := newsource()
+	cleared.Opaque = types.StringValue("var/cache/agent")
+
+	data := OCILayerResourceModel{
+		ArchiveResourceModel: plannedmodel(binary, removed, cleared),
+		DiffID:               types.StringUnknown(),
+		LayerDigest:          types.StringUnknown(),
+		MediaType:            types.StringUnknown(),
+	}
+	data.Reproducible = types.BoolValue(true)
+	data.SourceDateEpoch = types.Int64Value(1700000000)
+
+	plan := tfsdk.Plan{Schema: schemaresp.Schema}
+	require.False(t, plan.Set(ctx, &data).HasError())
+	resp := &resource.CreateResponse{State: tfsdk.State{Schema: schemaresp.Schema}}
+	r.Create(ctx, resource.CreateRequest{Plan: plan}, resp)
+	require.False(t, resp.Diagnostics.HasError(), "%v", resp.Diagnostics)
+
+	var created OCILayerResourceModel
+	require.False(t, resp.State.Get(ctx, &created).HasError())
+	require.Equal(t, "application/vnd.oci.image.layer.v1.tar+gzip", created.MediaType.ValueString())
+	require.Equal(t, "sha256:"+created.ArchiveDigest.ValueString(), created.LayerDigest.ValueString())
+
+	decoded, err := base64.StdEncoding.DecodeString(created.ArchiveB64.ValueString())
+	require.NoError(t, err)
+	cr, _, err := tarx.Decompress(bytes.NewReader(decoded))
+	require.NoError(t, err)
+	defer cr.Close()
+	uncompressed, err := io.ReadAll(cr)
+	require.NoError(t, err)
+	diffid := sha256.Sum256(uncompressed)
+	require.Equal(t, "sha256:"+hex.EncodeToString(diffid[:]), created.DiffID.ValueString())
+
+	type summary struct {
+		name  string
+		uid   int
+		uname string
+		size  int64
+	}
+
+	summaries := []summary{}
+	tr := tar.NewReader(bytes.NewReader(uncompressed))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		summaries = append(summaries, summary{name: hdr.Name, uid: hdr.Uid, uname: hdr.Uname, size: hdr.Size})
+	}
+
+	require.Equal(t, []summary{
+		{name: "etc/.wh.agent.conf"},
+		{name: "usr/bin/agent", uid: 1000, size: 10},
+		{name: "var/cache/agent/.wh..wh..opq"},
+	}, summaries)
+}
+
+func TestOCILayerResourceMediaTypes(t *testing.T) {
+	require.Equal(t, "application/vnd.oci.image.layer.v1.tar", formatocilayer.mimetype(string(tarx.CodecNone)))
+	require.Equal(t, "application/vnd.oci.image.layer.v1.tar+zstd", formatocilayer.mimetype(string(tarx.CodecZstd)))
+}
+
+func TestOCILayerResourceReservedNames(t *testing.T) {
+	r := &ArchiveResource{format: formatocilayer}
+
+	hidden := newsource()
+	hidden.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte("hello world")))
+	hidden.Location = types.StringValue("etc/.wh.hidden")
+	hidden.Gname = types.StringValue("wheel")
+
+	removed := newsource()
+	removed.Whiteout = types.StringValue("etc/hidden")
+
+	diags := diag.Diagnostics{}
+	validatelayer(&ArchiveResourceModel{Sources: []*SourceModel{hidden, removed}}, &diags)
+	require.Len(t, diags, 2)
+	require.Equal(t, path.Root("source").AtListIndex(0).AtName("gname"), diags[0].(diag.DiagnosticWithPath).Path())
+	require.Equal(t, path.Root("source").AtListIndex(0).AtName("location"), diags[1].(diag.DiagnosticWithPath).Path())
+
+	data := plannedmodel(hidden)
+	err := r.build(context.Background(), time.Time{}, &data, false)
+	require.ErrorContains(t, err, "etc/.wh.hidden: names prefixed with .wh. are reserved")
+	withpath, ok := errdiag("failed", err).(diag.DiagnosticWithPath)
+	require.True(t, ok)
+	require.Equal(t, path.Root("source").AtListIndex(0).AtName("base64"), withpath.Path())
+}
@@ -32,13 +32,39 @@ func (p *egt) Metadata(_ context.Context, _ provider.MetadataRequest, resp *prov
 	resp.Version = p.version
 }
 
+// providerModel describes the provider configuration.
+type providerModel struct {
+	Limits *LimitsModel `tfsdk:"limits"`
+}
+
+// providerdata is shared with resources once the provider is configured.
+type providerdata struct {
+	limits limits
+}
+
 // Schema defines the provider-level schema for configuration data.
 func (p *egt) Schema(ctx context.Context, _ provider.SchemaRequest, resp *provider.SchemaResponse) {
-	resp.Schema = schema.Schema{}
+	resp.Schema = schema.Schema{
+		Attributes: map[string]schema.Attribute{
+			"limits": limitsProviderSchema(),
+		},
+	}
 }
 
-// Configure prepares a HashiCups API client for data sources and resources.
+// Configure prepares the configuration shared by data sources and resources.
 func (p *egt) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+	var (
+		data providerModel
+	)
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pd := &providerdata{limits: data.Limits.limits()}
+	resp.DataSourceData = pd
+	resp.ResourceData = pd
 }
 
 // DataSources defines the data sources implemented in the provider.
@@ -50,5 +76,10 @@ func (p *egt) DataSources(_ context.Context) []func() datasource.DataSource {
 func (p *egt) Resources(_ context.Context) []func() resource.Resource {
 	return []func() resource.Resource{
 		NewTarResource,
+		NewZipResource,
+		NewCpioResource,
+		NewOCILayerResource,
+		NewOCIImageResource,
+		NewDebResource,
 	}
 }
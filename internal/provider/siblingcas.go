@@ -3,52 +3,168 @@ package provider
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
-	"fmt"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"time"
 
-	"github.com/hashicorp/terraform-plugin-framework/diag"
-	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
-	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
-func UseSHA256OfAttribute(attrname string) planmodifier.String {
-	return useSHA256OfAttribute{attrname: attrname}
+// digested is the content of the planned archive, every source is read once
+// and the digests of its entries shared by the attributes derived from them.
+type digested struct {
+	// digests of the entries of each source keyed by location, sha256.
+	digests map[*SourceModel]map[string]string
+	// digest of each source using the digest_algorithm.
+	sources map[*SourceModel]string
+	// missing sources read content produced during apply.
+	missing map[*SourceModel]bool
+	// manifest of the archive, nil unless every source is known and present.
+	manifest []ManifestModel
+	// latest modification time of the content read from disk.
+	latest time.Time
 }
 
-// useSHA256OfAttribute implements the plan modifier.
-type useSHA256OfAttribute struct {
-	attrname string
-}
+// digestsof the source, unknown until apply when its content is unknown or missing.
+func (t *digested) digestsof(v *SourceModel) types.Map {
+	if v.unknown() || t.missing[v] {
+		return basetypes.NewMapUnknown(types.StringType)
+	}
 
-// Description returns a human-readable description of the plan modifier.
-func (m useSHA256OfAttribute) Description(_ context.Context) string {
-	return "Once set, the value of this attribute in state will not change."
+	return digestsvalue(t.digests[v])
 }
 
-// MarkdownDescription returns a markdown description of the plan modifier.
-func (m useSHA256OfAttribute) MarkdownDescription(_ context.Context) string {
-	return "Once set, the value of this attribute in state will not change."
+// digestof the source, unknown until apply when its content is unknown or missing.
+func (t *digested) digestof(v *SourceModel, algorithm types.String) types.String {
+	if v.unknown() || t.missing[v] || algorithm.IsUnknown() {
+		return basetypes.NewStringUnknown()
+	}
+
+	return basetypes.NewStringValue(t.sources[v])
 }
 
-// PlanModifyString implements the plan modification logic.
-func (m useSHA256OfAttribute) PlanModifyString(ctx context.Context, req planmodifier.StringRequest, resp *planmodifier.StringResponse) {
+// digest the content of the sources, resolving the archive and hashing every
+// entry once. the limits associated with the context are enforced before the
+// entries are hashed, against the whole archive when it is known otherwise
+// against each known source on its own. sources whose content does not exist
+// yet, e.g. produced during apply, are recorded as missing.
+func digest(ctx context.Context, data *ArchiveResourceModel) (_ *digested, err error) {
 	var (
-		b64 types.String
+		members   []member
+		algorithm = data.DigestAlgorithm.ValueString()
+		d         = &digested{
+			digests: make(map[*SourceModel]map[string]string, len(data.Sources)),
+			sources: make(map[*SourceModel]string, len(data.Sources)),
+			missing: map[*SourceModel]bool{},
+		}
 	)
-	p := req.Path.ParentPath().AtName(m.attrname)
-	req.Plan.GetAttribute(ctx, p, &b64)
 
-	decoded, err := base64.StdEncoding.DecodeString(b64.ValueString())
-	if err != nil {
-		resp.Diagnostics.Append(diag.NewAttributeErrorDiagnostic(p, "failed to hash base64", err.Error()))
-		return
+	for i, v := range data.Sources {
+		if v.unknown() {
+			continue
+		}
+
+		p := path.Root("source").AtListIndex(i)
+		entries, err := v.entries(ctx, time.Time{})
+		if errors.Is(err, os.ErrNotExist) {
+			d.missing[v] = true
+			continue
+		} else if err != nil {
+			closemembers(members...)
+			return nil, attributed(p.AtName(v.kind()), err)
+		}
+
+		members = append(members, sourced(v, p, entries...)...)
+	}
+
+	whole := !data.unknown() && len(d.missing) == 0
+	if whole {
+		if members, err = arrange(ctx, time.Time{}, data, members); err != nil {
+			return nil, err
+		}
+	} else {
+		// the duplicates and parents of a partially known archive are unknown, check each source on its own.
+		for _, v := range data.Sources {
+			if err = limitsfrom(ctx).check(bysource(v, members...)...); err != nil {
+				closemembers(members...)
+				return nil, err
+			}
+		}
+	}
+	defer closemembers(members...)
+
+	hashes := make(map[*SourceModel]hash.Hash, len(data.Sources))
+	for _, v := range data.Sources {
+		d.digests[v] = map[string]string{}
+		hashes[v] = newhash(algorithm)
+	}
+
+	manifest := make([]ManifestModel, 0, len(members))
+	for _, e := range members {
+		// synthesized parent directories have no source.
+		if e.source == nil {
+			manifest = append(manifest, manifested(e.header, ""))
+			continue
+		}
+
+		if e.modified.After(d.latest) {
+			d.latest = e.modified
+		}
+
+		// dropped entries still contribute to the digests of their source.
+		checksum, err := e.hashed(hashes[e.source])
+		if errors.Is(err, os.ErrNotExist) {
+			d.missing[e.source] = true
+			continue
+		} else if err != nil {
+			return nil, attributed(e.path.AtName(e.source.kind()), err)
+		}
+		d.digests[e.source][e.header.Name] = checksum
+
+		if !e.dropped {
+			manifest = append(manifest, manifested(e.header, checksum))
+		}
+	}
+
+	for _, v := range data.Sources {
+		d.sources[v] = sourcedigest(v, d.digests[v], hashes[v])
+	}
+
+	if whole && len(d.missing) == 0 {
+		d.manifest = manifest
+	}
+
+	return d, nil
+}
+
+// hashed returns the sha256 of the member's content. file sources also write
+// their content to the digest of the source, directories and archives digest
+// the listing of their entries instead.
+func (t member) hashed(source io.Writer) (string, error) {
+	if t.source.expands() {
+		return t.digest()
+	}
+
+	digest := sha256.New()
+	if err := t.hash(io.MultiWriter(digest, source)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// bysource returns the members produced by the source.
+func bysource(v *SourceModel, members ...member) (selected []member) {
+	for _, e := range members {
+		if e.source == v {
+			selected = append(selected, e)
+		}
 	}
 
-	digest := sha256.Sum256(decoded)
-	encoded := hex.EncodeToString(digest[:])
-	tflog.Debug(ctx, fmt.Sprintf("sha256 plan: %s %s %s %s -> %s", p.String(), b64.ValueString(), decoded, req.StateValue.ValueString(), encoded))
-	resp.PlanValue = basetypes.NewStringValue(encoded)
+	return selected
 }
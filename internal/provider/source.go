@@ -0,0 +1,487 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/tarx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// reference resource: https://github.com/hashicorp/terraform-provider-local/blob/main/internal/provider/resource_local_file.go
+type SourceModel struct {
+	Base64      types.String `tfsdk:"base64"`
+	Path        types.String `tfsdk:"path"`
+	Directory   types.String `tfsdk:"directory"`
+	Symlink     types.String `tfsdk:"symlink"`
+	Hardlink    types.String `tfsdk:"hardlink"`
+	Device      types.String `tfsdk:"device"`
+	Major       types.Int64  `tfsdk:"major"`
+	Minor       types.Int64  `tfsdk:"minor"`
+	Whiteout    types.String `tfsdk:"whiteout"`
+	Opaque      types.String `tfsdk:"opaque"`
+	Template    types.String `tfsdk:"template"`
+	Vars        types.Map    `tfsdk:"vars"`
+	Archive     types.String `tfsdk:"archive"`
+	ArchiveB64  types.String `tfsdk:"archive_base64"`
+	StripPrefix types.String `tfsdk:"strip_prefix"`
+	Include     types.List   `tfsdk:"include"`
+	Exclude     types.List   `tfsdk:"exclude"`
+	IgnoreFiles types.List   `tfsdk:"ignore_files"`
+	Prefix      types.String `tfsdk:"prefix"`
+	Location    types.String `tfsdk:"location"`
+	Perm        types.Int32  `tfsdk:"perm"`
+	Uid         types.Int64  `tfsdk:"uid"`
+	Gid         types.Int64  `tfsdk:"gid"`
+	Uname       types.String `tfsdk:"uname"`
+	Gname       types.String `tfsdk:"gname"`
+	Mtime       types.Int64  `tfsdk:"mtime"`
+	Method      types.String `tfsdk:"method"`
+	Digest      types.String `tfsdk:"digest"`
+	Digests     types.Map    `tfsdk:"digests"`
+}
+
+// whiteouts record deletions from lower layers of OCI images.
+const (
+	// whiteoutprefix is prepended to the name of a removed file or directory.
+	whiteoutprefix = ".wh."
+	// whiteoutopaque within a directory hides the contents of the directory in lower layers.
+	whiteoutopaque = ".wh..wh..opq"
+)
+
+// devicetypes maps the device attribute to the type of the entry.
+var devicetypes = map[string]byte{
+	"char":  tar.TypeChar,
+	"block": tar.TypeBlock,
+	"fifo":  tar.TypeFifo,
+}
+
+func devicenames() (names []string) {
+	for name := range devicetypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// entry is a single member of an archive resolved from a source.
+type entry struct {
+	header *tar.Header
+	// open the content of the entry, nil for entries without content.
+	open func() (io.ReadCloser, error)
+	// modified is the modification time of the content on disk, zero when the
+	// content is provided by the configuration.
+	modified time.Time
+	// closer releases resources shared between entries, nil when there are none.
+	closer io.Closer
+	// checksum of the content computed while the entry was listed, empty when
+	// the content is only read once opened.
+	checksum string
+}
+
+// close releases the resources held by the entry.
+func (t entry) close() error {
+	if t.closer == nil {
+		return nil
+	}
+
+	return t.closer.Close()
+}
+
+func closeentries(entries ...entry) {
+	for _, e := range entries {
+		errorsx.Log(errorsx.Wrapf(e.close(), "failed to release %s", e.header.Name))
+	}
+}
+
+// unknown reports if any of the attributes determining the content of the
+// source are unknown.
+func (t *SourceModel) unknown() bool {
+	return t.Location.IsUnknown() || t.Base64.IsUnknown() || t.Path.IsUnknown() || t.Directory.IsUnknown() ||
+		t.Symlink.IsUnknown() || t.Hardlink.IsUnknown() || t.Template.IsUnknown() || !known(t.Vars) ||
+		t.Device.IsUnknown() || t.Major.IsUnknown() || t.Minor.IsUnknown() || t.Whiteout.IsUnknown() || t.Opaque.IsUnknown() ||
+		t.Archive.IsUnknown() || t.ArchiveB64.IsUnknown() || t.StripPrefix.IsUnknown() ||
+		t.Include.IsUnknown() || t.Exclude.IsUnknown() || t.IgnoreFiles.IsUnknown() || t.Prefix.IsUnknown()
+}
+
+// location of the entry within the archive.
+func (t *SourceModel) location() string {
+	return normalizelocation(t.Location.ValueString())
+}
+
+// locationattr returns the name of the attribute determining the location of the source's entries.
+func (t *SourceModel) locationattr() string {
+	if t.Location.IsNull() {
+		return t.kind()
+	}
+
+	return "location"
+}
+
+// kind returns the name of the attribute providing the content of the source.
+func (t *SourceModel) kind() string {
+	switch {
+	case !t.Directory.IsNull():
+		return "directory"
+	case !t.Path.IsNull():
+		return "path"
+	case !t.Symlink.IsNull():
+		return "symlink"
+	case !t.Hardlink.IsNull():
+		return "hardlink"
+	case !t.Device.IsNull():
+		return "device"
+	case !t.Whiteout.IsNull():
+		return "whiteout"
+	case !t.Opaque.IsNull():
+		return "opaque"
+	case !t.Template.IsNull():
+		return "template"
+	case !t.Archive.IsNull():
+		return "archive"
+	case !t.ArchiveB64.IsNull():
+		return "archive_base64"
+	default:
+		return "base64"
+	}
+}
+
+// entries resolves the source into the archive members it produces.
+func (t *SourceModel) entries(ctx context.Context, ts time.Time) ([]entry, error) {
+	switch {
+	case !t.Directory.IsNull():
+		return t.walk(ctx, ts)
+	case !t.Archive.IsNull(), !t.ArchiveB64.IsNull():
+		return t.extract(ctx)
+	case !t.Path.IsNull():
+		info, err := os.Stat(t.Path.ValueString())
+		if err != nil {
+			return nil, errorsx.UserFriendly(errorsx.Wrapf(err, "unable to read %s", t.Path.ValueString()))
+		}
+
+		return []entry{{
+			header:   t.header(t.location(), ts, info.Size(), 0600),
+			modified: info.ModTime(),
+			open: func() (io.ReadCloser, error) {
+				return os.Open(t.Path.ValueString())
+			},
+		}}, nil
+	case !t.Symlink.IsNull():
+		hdr := t.header(t.location(), ts, 0, 0777)
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = t.Symlink.ValueString()
+		return []entry{{header: hdr}}, nil
+	case !t.Hardlink.IsNull():
+		hdr := t.header(t.location(), ts, 0, 0600)
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = normalizelocation(t.Hardlink.ValueString())
+		return []entry{{header: hdr}}, nil
+	case !t.Device.IsNull():
+		hdr := t.header(t.location(), ts, 0, 0600)
+		hdr.Typeflag = devicetypes[t.Device.ValueString()]
+		hdr.Devmajor = t.Major.ValueInt64()
+		hdr.Devminor = t.Minor.ValueInt64()
+		return []entry{{header: hdr}}, nil
+	case !t.Whiteout.IsNull():
+		removed := normalizelocation(t.Whiteout.ValueString())
+		return []entry{empty(t.header(pathpkg.Join(pathpkg.Dir(removed), whiteoutprefix+pathpkg.Base(removed)), ts, 0, 0600))}, nil
+	case !t.Opaque.IsNull():
+		return []entry{empty(t.header(pathpkg.Join(normalizelocation(t.Opaque.ValueString()), whiteoutopaque), ts, 0, 0600))}, nil
+	case !t.Template.IsNull():
+		rendered, err := t.render(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return []entry{{
+			header: t.header(t.location(), ts, int64(len(rendered)), 0600),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(rendered)), nil
+			},
+		}}, nil
+	default:
+		// decode once to validate the content and determine its size without retaining it.
+		size, err := io.Copy(io.Discard, t.decoder())
+		if err != nil {
+			return nil, errorsx.UserFriendly(errorsx.Wrap(err, "invalid base64 content"))
+		}
+
+		return []entry{{
+			header: t.header(t.location(), ts, size, 0600),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(t.decoder()), nil
+			},
+		}}, nil
+	}
+}
+
+// empty entry with the header, e.g. a whiteout.
+func empty(hdr *tar.Header) entry {
+	return entry{
+		header: hdr,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		},
+	}
+}
+
+// decoder streams the decoded base64 content of the source.
+func (t *SourceModel) decoder() io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(t.Base64.ValueString()))
+}
+
+// render the template of the source with its vars, referencing a missing var is an error.
+func (t *SourceModel) render(ctx context.Context) ([]byte, error) {
+	var (
+		vars     map[string]string
+		rendered bytes.Buffer
+	)
+
+	if !t.Vars.IsNull() {
+		if diags := t.Vars.ElementsAs(ctx, &vars, false); diags.HasError() {
+			return nil, errorsx.Errorf("unable to decode vars: %v", diags)
+		}
+	}
+
+	tmpl, err := parsetemplate(t.location(), t.Template.ValueString())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tmpl.Execute(&rendered, vars); err != nil {
+		return nil, errorsx.UserFriendly(errorsx.Wrap(err, "unable to render template"))
+	}
+
+	return rendered.Bytes(), nil
+}
+
+func parsetemplate(name, body string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, errorsx.UserFriendly(errorsx.Wrap(err, "unable to parse template"))
+	}
+
+	return tmpl, nil
+}
+
+// walk the directory of the source, only files and symlinks are emitted.
+func (t *SourceModel) walk(ctx context.Context, ts time.Time) (entries []entry, err error) {
+	var (
+		include, exclude, ignorefiles []string
+	)
+
+	if err = errorsx.Compact(
+		liststrings(ctx, t.Include, &include),
+		liststrings(ctx, t.Exclude, &exclude),
+		liststrings(ctx, t.IgnoreFiles, &ignorefiles),
+	); err != nil {
+		return nil, err
+	}
+
+	found, err := tarx.Walk(
+		t.Directory.ValueString(),
+		tarx.WalkOptionInclude(include...),
+		tarx.WalkOptionExclude(exclude...),
+		tarx.WalkOptionIgnoreFiles(ignorefiles...),
+		tarx.WalkOptionPrefix(t.Prefix.ValueString()),
+	)
+	if err != nil {
+		return nil, errorsx.UserFriendly(errorsx.Wrapf(err, "unable to walk directory %s", t.Directory.ValueString()))
+	}
+
+	for _, f := range found {
+		mode := f.Info.Mode()
+		switch {
+		case mode.IsRegular():
+			entries = append(entries, entry{
+				header:   t.header(f.Name, ts, f.Info.Size(), int32(mode.Perm())),
+				modified: f.Info.ModTime(),
+				open: func() (io.ReadCloser, error) {
+					return os.Open(f.Path)
+				},
+			})
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(f.Path)
+			if err != nil {
+				return nil, errorsx.Wrapf(err, "failed to read symlink %s", f.Path)
+			}
+
+			hdr := t.header(f.Name, ts, 0, int32(mode.Perm()))
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+			entries = append(entries, entry{header: hdr, modified: f.Info.ModTime()})
+		}
+	}
+
+	return entries, nil
+}
+
+// header builds the tar header for an entry of the source, honoring any metadata overrides.
+func (t *SourceModel) header(name string, ts time.Time, size int64, mode int32) *tar.Header {
+	if !t.Perm.IsNull() {
+		mode = t.Perm.ValueInt32()
+	}
+
+	options := []tarx.HeaderOption{
+		tarx.HeaderOptionOwner(int(t.Uid.ValueInt64()), int(t.Gid.ValueInt64())),
+		tarx.HeaderOptionOwnerNames(t.Uname.ValueString(), t.Gname.ValueString()),
+	}
+
+	if !t.Mtime.IsNull() {
+		options = append(options, tarx.HeaderOptionModTime(time.UnixMilli(t.Mtime.ValueInt64())))
+	}
+
+	return tarx.NewHeader(name, ts, size, int64(mode), options...)
+}
+
+// digest of the entry's content, links are identified by their target and
+// device nodes by their type and numbers.
+func (t entry) digest() (_ string, err error) {
+	if t.checksum != "" {
+		return t.checksum, nil
+	}
+
+	digest := sha256.New()
+	if err = t.hash(digest); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// hash writes the content of the entry into the digest, links write their
+// target and device nodes their type and numbers.
+func (t entry) hash(digest io.Writer) (err error) {
+	switch {
+	case t.open != nil:
+	case t.header.Typeflag == tar.TypeChar, t.header.Typeflag == tar.TypeBlock, t.header.Typeflag == tar.TypeFifo:
+		_, _ = fmt.Fprintf(digest, "%s %d:%d", tarx.TypeName(t.header.Typeflag), t.header.Devmajor, t.header.Devminor)
+		return nil
+	default:
+		_, _ = io.WriteString(digest, t.header.Linkname)
+		return nil
+	}
+
+	src, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(digest, src)
+	return err
+}
+
+// sourcedigest summarizes the digests of the entries of a source. file
+// sources use the digest of their content, already written to the hash,
+// directories and archives use the digest of their listing.
+func sourcedigest(t *SourceModel, digests map[string]string, digest hash.Hash) string {
+	if t.expands() {
+		_, _ = io.WriteString(digest, listing(digests))
+	}
+
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// listing renders the digests in sha256sum format sorted by name.
+func listing(digests map[string]string) string {
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(&out, "%s  %s\n", digests[name], name)
+	}
+
+	return out.String()
+}
+
+func digestsvalue(digests map[string]string) types.Map {
+	values := make(map[string]attr.Value, len(digests))
+	for k, v := range digests {
+		values[k] = types.StringValue(v)
+	}
+
+	return types.MapValueMust(types.StringType, values)
+}
+
+// known reports if the map and every one of its elements are known.
+func known(m types.Map) bool {
+	if m.IsUnknown() {
+		return false
+	}
+
+	for _, v := range m.Elements() {
+		if v.IsUnknown() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func liststrings(ctx context.Context, l types.List, dst *[]string) error {
+	if l.IsNull() || l.IsUnknown() {
+		return nil
+	}
+
+	if diags := l.ElementsAs(ctx, dst, false); diags.HasError() {
+		return errorsx.Errorf("unable to decode list: %v", diags)
+	}
+
+	return nil
+}
+
+// newsource returns a source with every attribute null.
+func newsource() *SourceModel {
+	return &SourceModel{
+		Base64:      types.StringNull(),
+		Path:        types.StringNull(),
+		Directory:   types.StringNull(),
+		Symlink:     types.StringNull(),
+		Hardlink:    types.StringNull(),
+		Device:      types.StringNull(),
+		Major:       types.Int64Null(),
+		Minor:       types.Int64Null(),
+		Whiteout:    types.StringNull(),
+		Opaque:      types.StringNull(),
+		Template:    types.StringNull(),
+		Vars:        types.MapNull(types.StringType),
+		Archive:     types.StringNull(),
+		ArchiveB64:  types.StringNull(),
+		StripPrefix: types.StringNull(),
+		Include:     types.ListNull(types.StringType),
+		Exclude:     types.ListNull(types.StringType),
+		IgnoreFiles: types.ListNull(types.StringType),
+		Prefix:      types.StringNull(),
+		Location:    types.StringNull(),
+		Perm:        types.Int32Null(),
+		Uid:         types.Int64Null(),
+		Gid:         types.Int64Null(),
+		Uname:       types.StringNull(),
+		Gname:       types.StringNull(),
+		Mtime:       types.Int64Null(),
+		Method:      types.StringNull(),
+		Digest:      types.StringNull(),
+		Digests:     types.MapNull(types.StringType),
+	}
+}
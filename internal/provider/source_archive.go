@@ -0,0 +1,360 @@
+package provider
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/globx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/egdaemon/egt/internal/tarx"
+)
+
+// expands reports if the source produces a variable number of entries
+// whose locations are only known once the source is read.
+func (t *SourceModel) expands() bool {
+	return !t.Directory.IsNull() || t.archived()
+}
+
+// archived reports if the entries of the source are copied from an archive.
+func (t *SourceModel) archived() bool {
+	return !t.Archive.IsNull() || !t.ArchiveB64.IsNull()
+}
+
+// openarchive opens the compressed archive of the source.
+func (t *SourceModel) openarchive() (io.ReadCloser, error) {
+	if !t.Archive.IsNull() {
+		return os.Open(t.Archive.ValueString())
+	}
+
+	return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(t.ArchiveB64.ValueString()))), nil
+}
+
+// extract the entries of the archive selected by the source. entries outside
+// of strip_prefix are skipped, the remainder are filtered by include/exclude
+// relative to strip_prefix and placed beneath prefix. the original headers
+// are preserved except for the metadata the source overrides.
+func (t *SourceModel) extract(ctx context.Context) (entries []entry, err error) {
+	var (
+		include, exclude []string
+	)
+
+	if err = errorsx.Compact(
+		liststrings(ctx, t.Include, &include),
+		liststrings(ctx, t.Exclude, &exclude),
+	); err != nil {
+		return nil, err
+	}
+
+	c := &cursor{open: t.openarchive}
+	defer c.Close()
+
+	if err = c.reset(); err != nil {
+		return nil, errorsx.UserFriendly(err)
+	}
+
+	l := limitsfrom(ctx)
+	for {
+		hdr, err := c.next()
+		if err == io.EOF {
+			return entries, l.inflation(c.deflated.N, c.inflated.N)
+		} else if err != nil {
+			return nil, errorsx.UserFriendly(err)
+		}
+
+		// stop reading archives expanding beyond the compression ratio limit as early as possible.
+		if err = l.inflation(c.deflated.N, c.inflated.N); err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink, tar.TypeLink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		default:
+			continue
+		}
+
+		rel, ok, err := t.remap(hdr.Name)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		if excluded(rel, exclude...) || (len(include) > 0 && !globx.Any(rel, include...)) {
+			continue
+		}
+
+		copied := *hdr
+		copied.Name = path.Join(t.Prefix.ValueString(), rel)
+		copied.Format = tar.FormatUnknown
+		if copied.Typeflag == tar.TypeDir {
+			copied.Name += "/"
+		}
+
+		if copied.Typeflag == tar.TypeLink {
+			if target, ok, _ := t.remap(copied.Linkname); ok {
+				copied.Linkname = path.Join(t.Prefix.ValueString(), target)
+			}
+		}
+
+		t.override(&copied)
+
+		if copied.Typeflag != tar.TypeReg {
+			entries = append(entries, entry{header: &copied, modified: hdr.ModTime, closer: c})
+			continue
+		}
+
+		// the content is hashed while the archive is listed so planning
+		// decompresses it once, it is streamed from the archive when opened.
+		checksum := sha256.New()
+		if _, err = io.Copy(checksum, c.tr); err != nil {
+			return nil, errorsx.UserFriendly(errorsx.Wrapf(err, "unable to read %s from archive", hdr.Name))
+		}
+
+		idx := c.position
+		c.want(idx)
+		entries = append(entries, entry{
+			header:   &copied,
+			modified: hdr.ModTime,
+			closer:   c,
+			checksum: hex.EncodeToString(checksum.Sum(nil)),
+			open: func() (io.ReadCloser, error) {
+				r, err := c.member(idx)
+				if err != nil {
+					return nil, errorsx.Wrapf(err, "unable to read %s from archive", hdr.Name)
+				}
+
+				return io.NopCloser(r), nil
+			},
+		})
+	}
+}
+
+// cursor streams the members of an archive without retaining their content.
+// members read in order decompress the archive once. members skipped while
+// advancing to a later member are staged in a temporary file so reading them
+// afterwards, e.g. when reproducible archives sort the entries, does not
+// decompress the archive again.
+type cursor struct {
+	open     func() (io.ReadCloser, error)
+	src      io.ReadCloser
+	cr       io.ReadCloser
+	tr       *tar.Reader
+	position int
+	// streamed is the member whose content was last returned, it is never staged.
+	streamed int
+	// bytes read from the compressed archive and the bytes they expanded into.
+	deflated *iox.Counter
+	inflated *iox.Counter
+	// wanted are the members whose content is read, only those are staged.
+	wanted map[int]bool
+	staged *os.File
+	// sections of the staged file holding the content of each staged member.
+	sections map[int]*io.SectionReader
+}
+
+// want records the member's content will be read.
+func (t *cursor) want(idx int) {
+	if t.wanted == nil {
+		t.wanted = map[int]bool{}
+	}
+
+	t.wanted[idx] = true
+}
+
+// next advances the cursor to the following member.
+func (t *cursor) next() (*tar.Header, error) {
+	hdr, err := t.tr.Next()
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, errorsx.Wrap(err, "unable to read archive")
+	}
+
+	t.position++
+	return hdr, nil
+}
+
+// member positions the cursor at the member with the index returning a reader of its content.
+func (t *cursor) member(idx int) (io.Reader, error) {
+	if section, ok := t.sections[idx]; ok {
+		return io.NewSectionReader(section, 0, section.Size()), nil
+	}
+
+	// only a member read more than once precedes the position.
+	if t.tr == nil || idx <= t.position {
+		if err := t.reset(); err != nil {
+			return nil, err
+		}
+	}
+
+	for t.position < idx {
+		if t.position >= 0 && t.position != t.streamed && t.wanted[t.position] && t.sections[t.position] == nil {
+			if err := t.stage(); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := t.next(); err == io.EOF {
+			return nil, errorsx.Wrap(io.ErrUnexpectedEOF, "archive changed while being read")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	t.streamed = idx
+	return t.tr, nil
+}
+
+// stage the content of the current member.
+func (t *cursor) stage() (err error) {
+	if t.staged == nil {
+		if t.staged, err = os.CreateTemp("", ".egt.staged.*"); err != nil {
+			return errorsx.Wrap(err, "unable to stage archive members")
+		}
+	}
+
+	offset, err := t.staged.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errorsx.Wrap(err, "unable to stage archive members")
+	}
+
+	n, err := io.Copy(t.staged, t.tr)
+	if err != nil {
+		return errorsx.Wrap(err, "unable to stage archive members")
+	}
+
+	if t.sections == nil {
+		t.sections = map[int]*io.SectionReader{}
+	}
+
+	t.sections[t.position] = io.NewSectionReader(t.staged, offset, n)
+	return nil
+}
+
+// reset reopens the archive positioning the cursor before the first member.
+func (t *cursor) reset() (err error) {
+	if err = t.release(); err != nil {
+		return err
+	}
+
+	if t.src, err = t.open(); err != nil {
+		return errorsx.Wrap(err, "unable to open archive")
+	}
+
+	t.deflated, t.inflated = &iox.Counter{}, &iox.Counter{}
+	if t.cr, _, err = tarx.Decompress(io.TeeReader(t.src, t.deflated)); err != nil {
+		return errorsx.Wrap(err, "unable to read archive")
+	}
+
+	t.tr = tar.NewReader(io.TeeReader(t.cr, t.inflated))
+	t.position, t.streamed = -1, -1
+	return nil
+}
+
+// release the archive retaining the staged members.
+func (t *cursor) release() (err error) {
+	if t.cr != nil {
+		err = t.cr.Close()
+	}
+
+	if t.src != nil {
+		err = errorsx.Compact(err, t.src.Close())
+	}
+
+	t.src, t.cr, t.tr = nil, nil, nil
+	return err
+}
+
+// Close the archive and remove the staged members, the cursor reopens the
+// archive when a member is next requested.
+func (t *cursor) Close() (err error) {
+	err = t.release()
+	if t.staged != nil {
+		err = errorsx.Compact(err, t.staged.Close(), os.Remove(t.staged.Name()))
+	}
+
+	t.staged, t.sections = nil, nil
+	return err
+}
+
+// remap the name of an archive member relative to strip_prefix, reporting
+// false for the root and members outside of strip_prefix. members
+// traversing outside of the archive are rejected.
+func (t *SourceModel) remap(name string) (_ string, ok bool, err error) {
+	name = strings.TrimSuffix(strings.TrimLeft(name, "/"), "/")
+	if name == "" || name == "." {
+		return "", false, nil
+	}
+
+	if err = checklocation(name); err != nil {
+		return "", false, errorsx.UserFriendly(errorsx.Wrap(err, "invalid archive member"))
+	}
+	name = normalizelocation(name)
+
+	if t.StripPrefix.IsNull() || t.StripPrefix.ValueString() == "" {
+		return name, true, nil
+	}
+
+	strip := normalizelocation(t.StripPrefix.ValueString()) + "/"
+	if !strings.HasPrefix(name, strip) {
+		return "", false, nil
+	}
+
+	return strings.TrimPrefix(name, strip), true, nil
+}
+
+// override the header metadata explicitly set on the source.
+func (t *SourceModel) override(hdr *tar.Header) {
+	if !t.Perm.IsNull() {
+		hdr.Mode = int64(t.Perm.ValueInt32())
+	}
+
+	t.owner(hdr)
+
+	if !t.Mtime.IsNull() {
+		hdr.ModTime = time.UnixMilli(t.Mtime.ValueInt64())
+	}
+}
+
+// owner of the header explicitly set on the source.
+func (t *SourceModel) owner(hdr *tar.Header) {
+	if !t.Uid.IsNull() {
+		hdr.Uid = int(t.Uid.ValueInt64())
+	}
+
+	if !t.Gid.IsNull() {
+		hdr.Gid = int(t.Gid.ValueInt64())
+	}
+
+	if !t.Uname.IsNull() {
+		hdr.Uname = t.Uname.ValueString()
+	}
+
+	if !t.Gname.IsNull() {
+		hdr.Gname = t.Gname.ValueString()
+	}
+}
+
+// excluded reports if the name or any of its parents match the patterns.
+func excluded(name string, patterns ...string) bool {
+	if globx.Any(name, patterns...) {
+		return true
+	}
+
+	for _, dir := range tarx.Parents(name) {
+		if globx.Any(dir, patterns...) {
+			return true
+		}
+	}
+
+	return false
+}
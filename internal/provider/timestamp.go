@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"context"
+	"maps"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+const (
+	timestampOnChange       = "on-change"
+	timestampFixed          = "fixed"
+	timestampPerApply       = "per-apply"
+	timestampContentDerived = "content-derived"
+)
+
+// sourcedateepoch resolves the timestamp of reproducible archives, the
+// configured epoch takes precedence over the SOURCE_DATE_EPOCH environment
+// variable, defaulting to the unix epoch.
+func sourcedateepoch(configured types.Int64) (time.Time, error) {
+	if !configured.IsNull() && !configured.IsUnknown() {
+		return time.Unix(configured.ValueInt64(), 0).UTC(), nil
+	}
+
+	if raw, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok && raw != "" {
+		seconds, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return time.Time{}, errorsx.Wrapf(err, "invalid SOURCE_DATE_EPOCH %q", raw)
+		}
+
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+
+	return time.Unix(0, 0).UTC(), nil
+}
+
+// changed reports if the digest of any entry differs between the prior sources
+// and the content planned for the sources.
+func changed(ctx context.Context, prior, planned []*SourceModel, content *digested) (bool, error) {
+	if len(prior) != len(planned) {
+		return true, nil
+	}
+
+	for i, v := range planned {
+		var (
+			previous map[string]string
+		)
+
+		if prior[i].Digests.IsNull() || prior[i].Digests.IsUnknown() {
+			return true, nil
+		}
+
+		if diags := prior[i].Digests.ElementsAs(ctx, &previous, false); diags.HasError() {
+			return false, errorsx.Errorf("unable to decode digests: %v", diags)
+		}
+
+		if !maps.Equal(previous, content.digests[v]) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// plantimestamp plans the timestamp of the archive according to its
+// timestamp_policy from the content of its sources. reproducible archives
+// always use the source date epoch. prior is nil when the archive is created.
+func plantimestamp(ctx context.Context, data, prior *ArchiveResourceModel, content *digested) (types.Int64, error) {
+	if data.Reproducible.IsUnknown() || data.SourceDateEpoch.IsUnknown() || data.TimestampPolicy.IsUnknown() {
+		return types.Int64Unknown(), nil
+	}
+
+	policy := data.TimestampPolicy.ValueString()
+	if data.Reproducible.ValueBool() || policy == timestampFixed {
+		ts, err := sourcedateepoch(data.SourceDateEpoch)
+		if err != nil {
+			return types.Int64Unknown(), attributed(path.Root("source_date_epoch"), err)
+		}
+
+		return types.Int64Value(ts.UnixMilli()), nil
+	}
+
+	switch policy {
+	case timestampPerApply:
+		// the framework plans computed attributes as unknown whenever the
+		// resource changes, leaving the timestamp to be set during apply.
+		return data.Timestamp, nil
+	case timestampContentDerived:
+		// the content may be produced during apply, defer the timestamp until then.
+		if content.manifest == nil {
+			return types.Int64Unknown(), nil
+		}
+
+		ts := content.latest
+		if ts.IsZero() {
+			var err error
+			if ts, err = sourcedateepoch(data.SourceDateEpoch); err != nil {
+				return types.Int64Unknown(), attributed(path.Root("source_date_epoch"), err)
+			}
+		}
+
+		return types.Int64Value(ts.UnixMilli()), nil
+	}
+
+	// reset the timestamp when the content of any source changes.
+	if prior == nil || prior.Timestamp.IsNull() || content.manifest == nil {
+		return types.Int64Unknown(), nil
+	}
+
+	modified, err := changed(ctx, prior.Sources, data.Sources, content)
+	if err != nil {
+		return types.Int64Unknown(), err
+	}
+
+	if modified {
+		return types.Int64Unknown(), nil
+	}
+
+	return prior.Timestamp, nil
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveResourcePlanTimestamp(t *testing.T) {
+	ctx := context.Background()
+	r := &ArchiveResource{}
+
+	dir := t.TempDir()
+	modified := time.UnixMilli(1600000000000)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.txt"), []byte("hello world"), 0600))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "example.txt"), modified, modified))
+
+	source := func(contents string) *SourceModel {
+		src := newsource()
+		src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(contents)))
+		src.Location = types.StringValue("example.txt")
+		return src
+	}
+
+	// applied builds the archive returning its state.
+	applied := func(data ArchiveResourceModel) *ArchiveResourceModel {
+		data.Timestamp = types.Int64Value(1700000000000)
+		require.NoError(t, r.build(ctx, time.UnixMilli(data.Timestamp.ValueInt64()), &data, false))
+		return &data
+	}
+
+	planned := func(data ArchiveResourceModel, prior *ArchiveResourceModel) types.Int64 {
+		planned, diags := modifyplan(t, r, data, prior)
+		require.False(t, diags.HasError(), "%v", diags)
+		return planned.Timestamp
+	}
+
+	t.Run("on-change", func(t *testing.T) {
+		require.True(t, planned(plannedmodel(source("hello world")), nil).IsUnknown())
+
+		state := applied(plannedmodel(source("hello world")))
+		require.Equal(t, types.Int64Value(1700000000000), planned(plannedmodel(source("hello world")), state))
+		require.True(t, planned(plannedmodel(source("goodbye world")), state).IsUnknown())
+		extra := source("extra")
+		extra.Location = types.StringValue("extra.txt")
+		require.True(t, planned(plannedmodel(source("hello world"), extra), state).IsUnknown())
+	})
+
+	t.Run("fixed", func(t *testing.T) {
+		data := plannedmodel(source("hello world"))
+		data.TimestampPolicy = types.StringValue(timestampFixed)
+		data.SourceDateEpoch = types.Int64Value(1500000000)
+		require.Equal(t, types.Int64Value(1500000000000), planned(data, nil))
+	})
+
+	t.Run("per-apply", func(t *testing.T) {
+		data := plannedmodel(source("hello world"))
+		data.TimestampPolicy = types.StringValue(timestampPerApply)
+		require.True(t, planned(data, applied(data)).IsUnknown())
+	})
+
+	t.Run("content-derived", func(t *testing.T) {
+		src := newsource()
+		src.Directory = types.StringValue(dir)
+		src.Prefix = types.StringValue("rootfs")
+
+		data := plannedmodel(src, source("hello world"))
+		data.TimestampPolicy = types.StringValue(timestampContentDerived)
+		require.Equal(t, types.Int64Value(modified.UnixMilli()), planned(data, nil))
+
+		data = plannedmodel(source("hello world"))
+		data.TimestampPolicy = types.StringValue(timestampContentDerived)
+		data.SourceDateEpoch = types.Int64Value(1500000000)
+		require.Equal(t, types.Int64Value(1500000000000), planned(data, nil))
+	})
+}
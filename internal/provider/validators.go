@@ -0,0 +1,206 @@
+package provider
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	pathpkg "path"
+	"strings"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+)
+
+const (
+	duplicateError     = "error"
+	duplicateFirstWins = "first-wins"
+	duplicateLastWins  = "last-wins"
+)
+
+// normalizelocation cleans the location of an entry within the archive.
+func normalizelocation(location string) string {
+	return pathpkg.Clean(location)
+}
+
+// checklocation ensures the location stays within the extraction root of the archive.
+func checklocation(location string) error {
+	switch {
+	case location == "":
+		return errorsx.String("location must not be empty")
+	case strings.Contains(location, "\\"):
+		return errorsx.Errorf("location %q must use forward slashes", location)
+	case strings.HasPrefix(location, "/"):
+		return errorsx.Errorf("location %q must be relative", location)
+	case strings.HasSuffix(location, "/"):
+		return errorsx.Errorf("location %q must not have a trailing slash", location)
+	}
+
+	for _, segment := range strings.Split(location, "/") {
+		if segment == ".." {
+			return errorsx.Errorf("location %q must not traverse outside of the archive", location)
+		}
+	}
+
+	if cleaned := normalizelocation(location); cleaned == "." {
+		return errorsx.Errorf("location %q must name an entry", location)
+	}
+
+	return nil
+}
+
+// LocationValidator rejects archive locations that are absolute, traverse
+// outside of the archive root or have a trailing slash.
+func LocationValidator() validator.String {
+	return locationValidator{}
+}
+
+type locationValidator struct{}
+
+// Description returns a human-readable description of the validator.
+func (v locationValidator) Description(_ context.Context) string {
+	return "location must be a relative path within the archive without a trailing slash"
+}
+
+// MarkdownDescription returns a markdown description of the validator.
+func (v locationValidator) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+// ValidateString implements the validation logic.
+func (v locationValidator) ValidateString(ctx context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	if req.ConfigValue.IsNull() || req.ConfigValue.IsUnknown() {
+		return
+	}
+
+	if err := checklocation(req.ConfigValue.ValueString()); err != nil {
+		resp.Diagnostics.AddAttributeError(req.Path, "invalid location", err.Error())
+	}
+}
+
+// duplicates tracks the locations already present within an archive.
+type duplicates struct {
+	policy string
+	seen   map[string]int
+}
+
+func newduplicates(policy string) *duplicates {
+	if policy == "" {
+		policy = duplicateError
+	}
+
+	return &duplicates{policy: policy, seen: map[string]int{}}
+}
+
+// observe records the location at the index, returning the index of the
+// entry that should be dropped (-1 when none) under the policy.
+func (t *duplicates) observe(location string, idx int) (drop int, err error) {
+	prior, ok := t.seen[location]
+	if !ok {
+		t.seen[location] = idx
+		return -1, nil
+	}
+
+	switch t.policy {
+	case duplicateFirstWins:
+		return idx, nil
+	case duplicateLastWins:
+		t.seen[location] = idx
+		return prior, nil
+	default:
+		return -1, errorsx.UserFriendly(errorsx.Errorf("duplicate location %s within the archive, set on_duplicate to first-wins or last-wins to allow it", location))
+	}
+}
+
+// escapes reports if the symlink at the location resolves outside of the
+// archive root, absolute targets always escape.
+func escapes(location, target string) bool {
+	if strings.HasPrefix(target, "/") {
+		return true
+	}
+
+	resolved := pathpkg.Join(pathpkg.Dir(location), target)
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+// checklink ensures the link entry can be extracted, hardlinks must reference
+// an entry already written to the archive and when strict symlinks must
+// resolve within the archive root.
+func checklink(hdr *tar.Header, written map[string]bool, strict bool) error {
+	switch hdr.Typeflag {
+	case tar.TypeLink:
+		if !written[hdr.Linkname] {
+			return errorsx.UserFriendly(errorsx.Errorf("hardlink %s references %s which does not precede it within the archive", hdr.Name, hdr.Linkname))
+		}
+	case tar.TypeSymlink:
+		if strict && escapes(hdr.Name, hdr.Linkname) {
+			return errorsx.UserFriendly(errorsx.Errorf("symlink %s -> %s escapes the archive root, disable strict_links to allow it", hdr.Name, hdr.Linkname))
+		}
+	}
+
+	return nil
+}
+
+// RequiresAnyOf validates that when the attribute is set at least one of the
+// attributes matching the expressions is also set.
+func RequiresAnyOf(expressions ...path.Expression) requiresAnyOf {
+	return requiresAnyOf{expressions: expressions}
+}
+
+type requiresAnyOf struct {
+	expressions path.Expressions
+}
+
+// Description returns a human-readable description of the validator.
+func (v requiresAnyOf) Description(_ context.Context) string {
+	return fmt.Sprintf("requires at least one of %s to be set", v.expressions)
+}
+
+// MarkdownDescription returns a markdown description of the validator.
+func (v requiresAnyOf) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+// ValidateString implements the validation logic.
+func (v requiresAnyOf) ValidateString(ctx context.Context, req validator.StringRequest, resp *validator.StringResponse) {
+	v.validate(ctx, req.Config, req.ConfigValue, req.Path, req.PathExpression, &resp.Diagnostics)
+}
+
+// ValidateList implements the validation logic.
+func (v requiresAnyOf) ValidateList(ctx context.Context, req validator.ListRequest, resp *validator.ListResponse) {
+	v.validate(ctx, req.Config, req.ConfigValue, req.Path, req.PathExpression, &resp.Diagnostics)
+}
+
+func (v requiresAnyOf) validate(ctx context.Context, config tfsdk.Config, value attr.Value, p path.Path, expr path.Expression, diags *diag.Diagnostics) {
+	if value.IsNull() {
+		return
+	}
+
+	for _, expression := range expr.MergeExpressions(v.expressions...) {
+		matched, d := config.PathMatches(ctx, expression)
+		diags.Append(d...)
+		if d.HasError() {
+			return
+		}
+
+		for _, mp := range matched {
+			var (
+				sibling attr.Value
+			)
+
+			diags.Append(config.GetAttribute(ctx, mp, &sibling)...)
+			if diags.HasError() {
+				return
+			}
+
+			if !sibling.IsNull() {
+				return
+			}
+		}
+	}
+
+	diags.AddAttributeError(p, "missing required attribute", fmt.Sprintf("%s %s", p, v.Description(ctx)))
+}
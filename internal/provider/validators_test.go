@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLocation(t *testing.T) {
+	for _, valid := range []string{"example.txt", "etc/app/config.yaml", "./etc/app", "etc//app", ".hidden"} {
+		require.NoError(t, checklocation(valid), valid)
+	}
+
+	for _, invalid := range []string{"", "/etc/passwd", "../escape", "etc/../../escape", "etc/app/", "etc\\app", ".", "./"} {
+		require.Error(t, checklocation(invalid), invalid)
+	}
+
+	require.Equal(t, "etc/app", normalizelocation("./etc//app"))
+}
+
+func TestEscapes(t *testing.T) {
+	require.False(t, escapes("lib", "usr/lib"))
+	require.False(t, escapes("usr/lib/libc.so", "../lib64/libc.so"))
+	require.True(t, escapes("lib", "/usr/lib"))
+	require.True(t, escapes("lib", "../usr/lib"))
+	require.True(t, escapes("usr/lib/libc.so", "../../../lib/libc.so"))
+}
+
+func TestArchiveResourceDuplicates(t *testing.T) {
+	source := func(contents, location string) *SourceModel {
+		src := newsource()
+		src.Base64 = types.StringValue(base64.StdEncoding.EncodeToString([]byte(contents)))
+		src.Location = types.StringValue(location)
+		return src
+	}
+
+	build := func(policy string) (ArchiveResourceModel, error) {
+		data := ArchiveResourceModel{
+			OnDuplicate: types.StringValue(policy),
+			Sources: []*SourceModel{
+				source("first", "etc/config.yaml"),
+				source("other", "etc/other.yaml"),
+				source("second", "./etc//config.yaml"),
+			},
+		}
+
+		return data, (&ArchiveResource{}).build(context.Background(), time.Now(), &data, false)
+	}
+
+	data, err := build(duplicateError)
+	require.Error(t, err)
+	withpath, ok := errdiag("failed", err).(diag.DiagnosticWithPath)
+	require.True(t, ok)
+	require.Equal(t, path.Root("source").AtListIndex(2).AtName("location"), withpath.Path())
+
+	var diags diag.Diagnostics
+	validateduplicates(&data, &diags)
+	require.Len(t, diags, 1)
+	require.Equal(t, path.Root("source").AtListIndex(2).AtName("location"), diags[0].(diag.DiagnosticWithPath).Path())
+
+	data, err = build(duplicateFirstWins)
+	require.NoError(t, err)
+	entries := readarchiveb64(t, data.ArchiveB64.ValueString())
+	require.Len(t, entries, 2)
+	require.Equal(t, "etc/config.yaml", entries[0].header.Name)
+	require.Equal(t, "first", string(entries[0].contents))
+	require.False(t, data.Sources[2].Digest.IsNull())
+
+	data, err = build(duplicateLastWins)
+	require.NoError(t, err)
+	entries = readarchiveb64(t, data.ArchiveB64.ValueString())
+	require.Len(t, entries, 2)
+	require.Equal(t, "etc/other.yaml", entries[0].header.Name)
+	require.Equal(t, "etc/config.yaml", entries[1].header.Name)
+	require.Equal(t, "second", string(entries[1].contents))
+
+	diags = nil
+	validateduplicates(&data, &diags)
+	require.Empty(t, diags)
+}
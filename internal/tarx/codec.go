@@ -0,0 +1,168 @@
+package tarx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/iox"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec identifies the compression applied to an archive.
+type Codec string
+
+const (
+	CodecNone  Codec = "none"
+	CodecGzip  Codec = "gzip"
+	CodecZstd  Codec = "zstd"
+	CodecXz    Codec = "xz"
+	CodecBzip2 Codec = "bzip2"
+)
+
+// LevelDefault selects the default compression level of a codec.
+const LevelDefault = -1
+
+// WritableCodecs returns the codecs archives can be written with.
+func WritableCodecs() []Codec {
+	return []Codec{CodecNone, CodecGzip, CodecZstd, CodecXz}
+}
+
+// Mimetype of archives compressed with the codec.
+func (t Codec) Mimetype() string {
+	switch t {
+	case CodecNone:
+		return "application/x-tar"
+	default:
+		return "application/tar+" + string(t)
+	}
+}
+
+// Levels returns the inclusive range of compression levels the codec accepts.
+func (t Codec) Levels() (lowest, highest int) {
+	switch t {
+	case CodecGzip:
+		return gzip.NoCompression, gzip.BestCompression
+	case CodecZstd:
+		return 1, 22
+	case CodecXz:
+		return 0, 9
+	default:
+		return 0, 0
+	}
+}
+
+// NewWriter returns a writer compressing into dst. closing the writer
+// does not close dst.
+func (t Codec) NewWriter(dst io.Writer, level int) (io.WriteCloser, error) {
+	if lowest, highest := t.Levels(); level != LevelDefault && (level < lowest || level > highest) {
+		return nil, errorsx.Errorf("invalid %s compression level %d, must be between %d and %d", t, level, lowest, highest)
+	}
+
+	switch t {
+	case CodecNone:
+		return iox.WriteNopCloser(dst), nil
+	case CodecGzip:
+		gw, err := gzip.NewWriterLevel(dst, level)
+		if err != nil {
+			return nil, err
+		}
+		// pin the header so identical content produces identical bytes.
+		gw.Header = gzip.Header{OS: 255}
+		return gw, nil
+	case CodecZstd:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level != LevelDefault {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(dst, options...)
+	case CodecXz:
+		config := xz.WriterConfig{}
+		if level != LevelDefault {
+			config.DictCap = xzdictcap[level]
+		}
+		return config.NewWriter(dst)
+	default:
+		return nil, errorsx.Errorf("%s compression is not supported for writing", t)
+	}
+}
+
+// NewReader returns a reader decompressing src.
+func (t Codec) NewReader(src io.Reader) (io.ReadCloser, error) {
+	switch t {
+	case CodecNone:
+		return io.NopCloser(src), nil
+	case CodecGzip:
+		return gzip.NewReader(src)
+	case CodecZstd:
+		d, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CodecXz:
+		r, err := xz.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(r), nil
+	case CodecBzip2:
+		return io.NopCloser(bzip2.NewReader(src)), nil
+	default:
+		return nil, errorsx.Errorf("unknown compression %s", t)
+	}
+}
+
+// dictionary capacities matching the xz utility's presets.
+var xzdictcap = [...]int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+var magics = []struct {
+	codec Codec
+	magic []byte
+}{
+	{codec: CodecGzip, magic: []byte{0x1f, 0x8b}},
+	{codec: CodecZstd, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{codec: CodecXz, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{codec: CodecBzip2, magic: []byte{'B', 'Z', 'h'}},
+}
+
+// Detect the codec from the magic bytes at the start of the reader,
+// uncompressed archives are reported as CodecNone.
+func Detect(r *bufio.Reader) (Codec, error) {
+	header, err := r.Peek(6)
+	if err != nil && err != io.EOF {
+		return CodecNone, err
+	}
+
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.codec, nil
+		}
+	}
+
+	return CodecNone, nil
+}
+
+// Decompress detects the codec of the archive and returns a reader of its
+// uncompressed content.
+func Decompress(src io.Reader) (io.ReadCloser, Codec, error) {
+	buffered := bufio.NewReader(src)
+	codec, err := Detect(buffered)
+	if err != nil {
+		return nil, codec, errorsx.Wrap(err, "failed to detect compression")
+	}
+
+	r, err := codec.NewReader(buffered)
+	if err != nil {
+		return nil, codec, errorsx.Wrapf(err, "failed to create %s reader", codec)
+	}
+
+	return r, codec, nil
+}
@@ -0,0 +1,70 @@
+package tarx_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/egdaemon/egt/internal/tarx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range WritableCodecs() {
+		t.Run(string(codec), func(t *testing.T) {
+			var buf bytes.Buffer
+			contents := bytes.Repeat([]byte("hello world "), 1024)
+
+			lowest, _ := codec.Levels()
+			cw, err := codec.NewWriter(&buf, lowest)
+			assert.NoError(t, err)
+			_, err = cw.Write(contents)
+			assert.NoError(t, err)
+			assert.NoError(t, cw.Close())
+
+			cr, detected, err := Decompress(&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, codec, detected)
+			decoded, err := io.ReadAll(cr)
+			assert.NoError(t, err)
+			assert.NoError(t, cr.Close())
+			assert.Equal(t, contents, decoded)
+		})
+	}
+}
+
+func TestCodecInvalidLevel(t *testing.T) {
+	_, err := CodecGzip.NewWriter(io.Discard, 10)
+	assert.Error(t, err)
+	_, err = CodecBzip2.NewWriter(io.Discard, LevelDefault)
+	assert.Error(t, err)
+}
+
+func TestPackWithInspect(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "example.txt"), []byte("hello world"), 0600))
+
+	for _, codec := range WritableCodecs() {
+		t.Run(string(codec), func(t *testing.T) {
+			dst, err := os.CreateTemp(t.TempDir(), "archive.*")
+			assert.NoError(t, err)
+			defer dst.Close()
+
+			assert.NoError(t, PackWith(dst, codec, LevelDefault, dir))
+			assert.NoError(t, Inspect(context.Background(), dst))
+
+			cr, detected, err := Decompress(dst)
+			assert.NoError(t, err)
+			defer cr.Close()
+			assert.Equal(t, codec, detected)
+
+			hdr, err := tar.NewReader(cr).Next()
+			assert.NoError(t, err)
+			assert.Equal(t, "example.txt", hdr.Name)
+		})
+	}
+}
@@ -2,20 +2,24 @@ package tarx
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
+	"errors"
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/globx"
 	"github.com/egdaemon/egt/internal/iox"
 )
 
 const (
+	// Mimetype of gzip compressed archives, see Codec.Mimetype for other codecs.
 	Mimetype = "application/tar+gzip"
 )
 
@@ -37,14 +41,113 @@ func HeaderFromReader(filename string, reader *strings.Reader) (hdr *tar.Header)
 	}
 }
 
-// NewHeader creates a new header.
-func NewHeader(filename string, ts time.Time, size, mode int64) (hdr *tar.Header) {
-	return &tar.Header{
+// HeaderOption customizes a header created by NewHeader.
+type HeaderOption func(*tar.Header)
+
+// HeaderOptionOwner sets the numeric user and group ids of the entry.
+func HeaderOptionOwner(uid, gid int) HeaderOption {
+	return func(hdr *tar.Header) {
+		hdr.Uid = uid
+		hdr.Gid = gid
+	}
+}
+
+// HeaderOptionOwnerNames sets the user and group names of the entry.
+func HeaderOptionOwnerNames(uname, gname string) HeaderOption {
+	return func(hdr *tar.Header) {
+		hdr.Uname = uname
+		hdr.Gname = gname
+	}
+}
+
+// HeaderOptionModTime overrides the modification time of the entry.
+func HeaderOptionModTime(ts time.Time) HeaderOption {
+	return func(hdr *tar.Header) {
+		hdr.ModTime = ts
+	}
+}
+
+// NewHeader creates a new header for a regular file.
+func NewHeader(filename string, ts time.Time, size, mode int64, options ...HeaderOption) (hdr *tar.Header) {
+	hdr = &tar.Header{
+		Typeflag:   tar.TypeReg,
 		Name:       filename,
-		Mode:       0600,
+		Mode:       mode,
 		Size:       size,
+		ModTime:    ts,
 		ChangeTime: ts,
 	}
+
+	for _, opt := range options {
+		opt(hdr)
+	}
+
+	return hdr
+}
+
+// NewDirHeader creates a new header for a directory.
+func NewDirHeader(dirname string, ts time.Time, mode int64, options ...HeaderOption) (hdr *tar.Header) {
+	hdr = NewHeader(strings.TrimSuffix(dirname, "/")+"/", ts, 0, mode, options...)
+	hdr.Typeflag = tar.TypeDir
+	return hdr
+}
+
+// Parents returns the parent directories of the slash separated name
+// ordered from the root, e.g. etc/app/config.yaml -> [etc etc/app].
+func Parents(name string) (parents []string) {
+	name = strings.Trim(path.Clean(name), "/")
+	for i, c := range name {
+		if c == '/' {
+			parents = append(parents, name[:i])
+		}
+	}
+
+	return parents
+}
+
+// Normalize the header for reproducible archives. access and change times
+// are dropped, the modification time is truncated to seconds in UTC, only
+// permission bits are retained and the format is pinned to PAX.
+func Normalize(hdr *tar.Header) *tar.Header {
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second).UTC()
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	hdr.Mode &= 07777
+	hdr.Format = tar.FormatPAX
+	hdr.PAXRecords = nil
+	return hdr
+}
+
+// NormalizeOwner resets the ownership of the header to uid and gid 0 without
+// user or group names, the owner of the machine building the archive or of an
+// upstream archive is not retained.
+func NormalizeOwner(hdr *tar.Header) *tar.Header {
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+	return hdr
+}
+
+// TypeName describes the type of an entry; file, directory, symlink, hardlink,
+// char, block or fifo.
+func TypeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return "type " + string(typeflag)
+	}
 }
 
 func NewHeaderFromSeeker(filename string, in io.Seeker) (hdr *tar.Header, err error) {
@@ -115,11 +218,11 @@ func WriteToArchive(tw *tar.Writer, filename string, in io.ReadSeeker) (err erro
 	return nil
 }
 
-// prints to stderr information about the archive
+// prints to stderr information about the archive, the compression codec is detected automatically.
 func Inspect(ctx context.Context, r io.Reader) (err error) {
 	var (
-		gzr *gzip.Reader
-		tr  *tar.Reader
+		cr io.ReadCloser
+		tr *tar.Reader
 	)
 
 	if s, ok := r.(io.Seeker); ok {
@@ -130,12 +233,12 @@ func Inspect(ctx context.Context, r io.Reader) (err error) {
 		defer func() { errorsx.MaybeLog(errorsx.Wrap(iox.Rewind(s), "unable to rewind")) }()
 	}
 
-	if gzr, err = gzip.NewReader(r); err != nil {
-		return errorsx.Wrap(err, "failed to create gzip reader")
+	if cr, _, err = Decompress(r); err != nil {
+		return err
 	}
-	defer gzr.Close()
+	defer cr.Close()
 
-	tr = tar.NewReader(gzr)
+	tr = tar.NewReader(cr)
 
 	for {
 		header, err := tr.Next()
@@ -161,10 +264,152 @@ func Inspect(ctx context.Context, r io.Reader) (err error) {
 	}
 }
 
-// Pack the set of paths into the archive. caller is responsible for rewinding the writer.
+// WalkOption customizes the behaviour of Walk.
+type WalkOption func(*walker)
+
+// WalkOptionInclude only archive files whose path relative to the
+// directory matches at least one of the patterns.
+func WalkOptionInclude(patterns ...string) WalkOption {
+	return func(w *walker) {
+		w.include = append(w.include, patterns...)
+	}
+}
+
+// WalkOptionExclude skip files and directories whose path relative to the
+// directory matches any of the patterns.
+func WalkOptionExclude(patterns ...string) WalkOption {
+	return func(w *walker) {
+		w.exclude = append(w.exclude, patterns...)
+	}
+}
+
+// WalkOptionIgnoreFiles honor gitignore style files with the given names
+// found anywhere within the directory.
+func WalkOptionIgnoreFiles(names ...string) WalkOption {
+	return func(w *walker) {
+		w.ignorefiles = append(w.ignorefiles, names...)
+	}
+}
+
+// WalkOptionPrefix prefix the archive names of the entries.
+func WalkOptionPrefix(prefix string) WalkOption {
+	return func(w *walker) {
+		w.prefix = prefix
+	}
+}
+
+type walker struct {
+	include     []string
+	exclude     []string
+	ignorefiles []string
+	prefix      string
+}
+
+// WalkEntry is a file or directory discovered by Walk.
+type WalkEntry struct {
+	Path string // location on disk.
+	Name string // slash separated name within the archive.
+	Info os.FileInfo
+}
+
+// Walk the basepath returning the entries to archive sorted by name.
+// the root directory itself is never returned.
+func Walk(basepath string, options ...WalkOption) (entries []WalkEntry, err error) {
+	var (
+		w     walker
+		rules []globx.Rule
+	)
+
+	for _, opt := range options {
+		opt(&w)
+	}
+
+	walkfn := func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(basepath, current)
+		if err != nil {
+			return errorsx.Wrapf(err, "failed to compute path: %s", current)
+		}
+		rel = filepath.ToSlash(rel)
+
+		// base and path are identical
+		if rel == "." {
+			if info.IsDir() {
+				return w.ignores(current, "", &rules)
+			}
+
+			rel = filepath.Base(current)
+		}
+
+		if globx.Any(rel, w.exclude...) || globx.Ignored(rel, info.IsDir(), rules...) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.IsDir() {
+			if err = w.ignores(current, rel, &rules); err != nil {
+				return err
+			}
+		} else if len(w.include) > 0 && !globx.Any(rel, w.include...) {
+			return nil
+		}
+
+		entries = append(entries, WalkEntry{
+			Path: current,
+			Name: path.Join(w.prefix, rel),
+			Info: info,
+		})
+
+		return nil
+	}
+
+	if err = filepath.Walk(basepath, walkfn); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, nil
+}
+
+// ignores loads the ignore files present in the directory.
+func (t walker) ignores(dir, rel string, rules *[]globx.Rule) error {
+	for _, name := range t.ignorefiles {
+		src, err := os.Open(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return errorsx.Wrapf(err, "failed to open ignore file: %s", name)
+		}
+
+		parsed, err := globx.ParseIgnore(rel, src)
+		if err = errorsx.Compact(err, src.Close()); err != nil {
+			return errorsx.Wrapf(err, "failed to parse ignore file: %s", filepath.Join(dir, name))
+		}
+
+		*rules = append(*rules, parsed...)
+	}
+
+	return nil
+}
+
+// Pack the set of paths into a gzip compressed archive. caller is responsible for rewinding the writer.
 func Pack(dst io.Writer, paths ...string) (err error) {
+	return PackWith(dst, CodecGzip, LevelDefault, paths...)
+}
+
+// PackWith the set of paths into an archive compressed with the codec. caller is responsible for rewinding the writer.
+func PackWith(dst io.Writer, codec Codec, level int, paths ...string) (err error) {
 	var (
-		gw *gzip.Writer
+		cw io.WriteCloser
 		tw *tar.Writer
 	)
 
@@ -172,49 +417,35 @@ func Pack(dst io.Writer, paths ...string) (err error) {
 		defer errorsx.MaybeLog(errorsx.Wrap(iox.Rewind(s), "unable to rewind archive"))
 	}
 
-	gw = gzip.NewWriter(dst)
-	defer gw.Close()
-	tw = tar.NewWriter(gw)
+	if cw, err = codec.NewWriter(dst, level); err != nil {
+		return errorsx.Wrapf(err, "failed to create %s writer", codec)
+	}
+	defer cw.Close()
+	tw = tar.NewWriter(cw)
 	defer tw.Close()
 
 	for _, basepath := range paths {
-		walker := func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// skip the root directory itself.
-			if basepath == path && info.IsDir() {
-				return nil
-			}
-
-			return write(basepath, path, tw, info)
+		entries, err := Walk(basepath)
+		if err != nil {
+			return err
 		}
 
-		if err = filepath.Walk(basepath, walker); err != nil {
-			return err
+		for _, e := range entries {
+			if err = write(e.Path, e.Name, tw, e.Info); err != nil {
+				return err
+			}
 		}
 	}
 
 	return errorsx.Wrap(tw.Flush(), "failed to flush archive")
 }
 
-func write(basepath, path string, tw *tar.Writer, info os.FileInfo) (err error) {
+func write(path, target string, tw *tar.Writer, info os.FileInfo) (err error) {
 	var (
 		src    *os.File
 		header *tar.Header
-		target string
 	)
 
-	if target, err = filepath.Rel(basepath, path); err != nil {
-		return errorsx.Wrapf(err, "failed to compute path: %s", path)
-	}
-
-	// base and path are identical
-	if target == "." {
-		target = filepath.Base(path)
-	}
-
 	// log.Println("writing", path, "->", target)
 	if src, err = os.Open(path); err != nil {
 		return errorsx.Wrap(err, "failed to open path")
@@ -1,8 +1,10 @@
 package tarx_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -32,3 +34,74 @@ func TestTarxWriteFileToArchive(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, tw.Flush())
 }
+
+func TestTarxNewHeaderMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	ts := time.Unix(1700000000, 0)
+	mtime := time.Unix(1600000000, 0)
+	contents := []byte("#!/bin/sh\necho hello\n")
+
+	tw := tar.NewWriter(&buf)
+	hdr := NewHeader(
+		"bin/hello", ts, int64(len(contents)), 0755,
+		HeaderOptionOwner(1000, 1001),
+		HeaderOptionOwnerNames("root", "wheel"),
+		HeaderOptionModTime(mtime),
+	)
+	assert.NoError(t, WriteFileToArchive(tw, hdr, bytes.NewReader(contents)))
+	assert.NoError(t, tw.Close())
+
+	tr := tar.NewReader(&buf)
+	read, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "bin/hello", read.Name)
+	assert.Equal(t, byte(tar.TypeReg), read.Typeflag)
+	assert.Equal(t, int64(0755), read.Mode)
+	assert.Equal(t, 1000, read.Uid)
+	assert.Equal(t, 1001, read.Gid)
+	assert.Equal(t, "root", read.Uname)
+	assert.Equal(t, "wheel", read.Gname)
+	assert.True(t, mtime.Equal(read.ModTime))
+	assert.Equal(t, int64(len(contents)), read.Size)
+}
+
+func TestTarxWalk(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		".gitignore":          "*.log\n",
+		"b.yaml":              "b",
+		"a.yaml":              "a",
+		"debug.log":           "log",
+		"etc/app/config.yaml": "config",
+		"etc/app/notes.txt":   "notes",
+		"tmp/scratch.yaml":    "scratch",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600))
+	}
+
+	entries, err := Walk(
+		dir,
+		WalkOptionInclude("**/*.yaml"),
+		WalkOptionExclude("tmp"),
+		WalkOptionIgnoreFiles(".gitignore"),
+		WalkOptionPrefix("opt"),
+	)
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, e := range entries {
+		if e.Info.IsDir() {
+			continue
+		}
+		names = append(names, e.Name)
+	}
+
+	assert.Equal(t, []string{"opt/a.yaml", "opt/b.yaml", "opt/etc/app/config.yaml"}, names)
+}
+
+func TestTarxParents(t *testing.T) {
+	assert.Equal(t, []string{"etc", "etc/app"}, Parents("etc/app/config.yaml"))
+	assert.Equal(t, []string{"etc"}, Parents("etc/app/"))
+	assert.Empty(t, Parents("config.yaml"))
+}
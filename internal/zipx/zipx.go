@@ -0,0 +1,167 @@
+// Package zipx writes zip archives from tar headers so the entries of an
+// archive can be produced independently of its format.
+package zipx
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"strings"
+
+	"github.com/egdaemon/egt/internal/errorsx"
+	"github.com/egdaemon/egt/internal/tarx"
+)
+
+// Method identifies the compression applied to the files of a zip archive.
+type Method string
+
+const (
+	MethodStore   Method = "store"
+	MethodDeflate Method = "deflate"
+)
+
+// LevelDefault selects the default compression level of a method.
+const LevelDefault = -1
+
+// Mimetype of zip archives.
+const Mimetype = "application/zip"
+
+// Methods returns the methods archives can be written with.
+func Methods() []Method {
+	return []Method{MethodStore, MethodDeflate}
+}
+
+// Levels returns the inclusive range of compression levels the method accepts.
+func (t Method) Levels() (lowest, highest int) {
+	switch t {
+	case MethodDeflate:
+		return flate.NoCompression, flate.BestCompression
+	default:
+		return 0, 0
+	}
+}
+
+func (t Method) id() (uint16, error) {
+	switch t {
+	case MethodStore:
+		return zip.Store, nil
+	case MethodDeflate:
+		return zip.Deflate, nil
+	default:
+		return 0, errorsx.Errorf("%s compression is not supported for zip archives", t)
+	}
+}
+
+// Writer writes entries described by tar headers into a zip archive.
+type Writer struct {
+	zw     *zip.Writer
+	method uint16
+}
+
+// NewWriter returns a writer compressing files with the method. closing
+// the writer does not close dst.
+func NewWriter(dst io.Writer, method Method, level int) (_ *Writer, err error) {
+	var (
+		id uint16
+	)
+
+	if lowest, highest := method.Levels(); level != LevelDefault && (level < lowest || level > highest) {
+		return nil, errorsx.Errorf("invalid %s compression level %d, must be between %d and %d", method, level, lowest, highest)
+	}
+
+	if id, err = method.id(); err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(dst)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	return &Writer{zw: zw, method: id}, nil
+}
+
+// WriteHeader writes an entry without content, the target of symlinks is
+// recorded as their content.
+func (t *Writer) WriteHeader(hdr *tar.Header) error {
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		return t.WriteFile(hdr, strings.NewReader(hdr.Linkname))
+	default:
+		return t.WriteFile(hdr, strings.NewReader(""))
+	}
+}
+
+// WriteFile writes an entry along with its content. directories, links and
+// empty files are stored, everything else uses the method of the writer.
+func (t *Writer) WriteFile(hdr *tar.Header, src io.Reader) error {
+	return t.write(hdr, t.method, src)
+}
+
+// WriteFileMethod writes an entry along with its content compressed with the
+// method instead of the method of the writer. deflate uses the level of the writer.
+func (t *Writer) WriteFileMethod(hdr *tar.Header, method Method, src io.Reader) error {
+	id, err := method.id()
+	if err != nil {
+		return err
+	}
+
+	return t.write(hdr, id, src)
+}
+
+func (t *Writer) write(hdr *tar.Header, method uint16, src io.Reader) (err error) {
+	var (
+		fh *zip.FileHeader
+		w  io.Writer
+	)
+
+	if fh, err = FileHeader(hdr, method); err != nil {
+		return err
+	}
+
+	if w, err = t.zw.CreateHeader(fh); err != nil {
+		return errorsx.Wrapf(err, "failed to write header for %s", hdr.Name)
+	}
+
+	if _, err = io.Copy(w, src); err != nil {
+		return errorsx.Wrap(err, "failed to copy content")
+	}
+
+	return nil
+}
+
+// Close finishes the archive.
+func (t *Writer) Close() error {
+	return t.zw.Close()
+}
+
+// FileHeader converts the tar header into the header of a zip entry
+// compressed with the method. the unix permission bits and file type are
+// retained in the external attributes, ownership is not representable.
+func FileHeader(hdr *tar.Header, method uint16) (*zip.FileHeader, error) {
+	fh := &zip.FileHeader{
+		Name:     strings.TrimLeft(hdr.Name, "/"),
+		Modified: hdr.ModTime.UTC(),
+		Method:   method,
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		if hdr.Size == 0 {
+			fh.Method = zip.Store
+		}
+	case tar.TypeDir:
+		fh.Method = zip.Store
+		if !strings.HasSuffix(fh.Name, "/") {
+			fh.Name += "/"
+		}
+	case tar.TypeSymlink:
+		fh.Method = zip.Store
+	default:
+		return nil, errorsx.Errorf("%s: zip archives do not support %s entries", hdr.Name, tarx.TypeName(hdr.Typeflag))
+	}
+
+	fh.SetMode(hdr.FileInfo().Mode())
+	return fh, nil
+}
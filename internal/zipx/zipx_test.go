@@ -0,0 +1,81 @@
+package zipx_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/egdaemon/egt/internal/zipx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZipxRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	contents := strings.Repeat("hello world\n", 128)
+
+	for _, method := range Methods() {
+		t.Run(string(method), func(t *testing.T) {
+			var buf bytes.Buffer
+			zw, err := NewWriter(&buf, method, LevelDefault)
+			require.NoError(t, err)
+			require.NoError(t, zw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: ts}))
+			require.NoError(t, zw.WriteFile(&tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(contents)), ModTime: ts}, strings.NewReader(contents)))
+			require.NoError(t, zw.WriteFile(&tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0600, ModTime: ts}, strings.NewReader("")))
+			require.NoError(t, zw.WriteHeader(&tar.Header{Name: "tool", Typeflag: tar.TypeSymlink, Linkname: "bin/tool", Mode: 0777, ModTime: ts}))
+			require.NoError(t, zw.WriteFileMethod(&tar.Header{Name: "stored", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents)), ModTime: ts}, MethodStore, strings.NewReader(contents)))
+			require.NoError(t, zw.Close())
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			require.Len(t, zr.File, 5)
+
+			expected := []struct {
+				name     string
+				mode     fs.FileMode
+				method   uint16
+				contents string
+			}{
+				{name: "bin/", mode: fs.ModeDir | 0755, method: zip.Store},
+				{name: "bin/tool", mode: 0755, method: map[Method]uint16{MethodStore: zip.Store, MethodDeflate: zip.Deflate}[method], contents: contents},
+				{name: "empty", mode: 0600, method: zip.Store},
+				{name: "tool", mode: fs.ModeSymlink | 0777, method: zip.Store, contents: "bin/tool"},
+				{name: "stored", mode: 0644, method: zip.Store, contents: contents},
+			}
+
+			for i, e := range expected {
+				f := zr.File[i]
+				require.Equal(t, e.name, f.Name)
+				require.Equal(t, e.mode, f.Mode())
+				require.Equal(t, e.method, f.Method)
+				require.True(t, ts.Equal(f.Modified), "%s modified %s", f.Name, f.Modified)
+
+				r, err := f.Open()
+				require.NoError(t, err)
+				b, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				require.Equal(t, e.contents, string(b))
+			}
+		})
+	}
+}
+
+func TestZipxUnsupported(t *testing.T) {
+	_, err := NewWriter(io.Discard, MethodDeflate, 10)
+	require.Error(t, err)
+
+	_, err = NewWriter(io.Discard, Method("bzip2"), LevelDefault)
+	require.Error(t, err)
+
+	zw, err := NewWriter(io.Discard, MethodDeflate, LevelDefault)
+	require.NoError(t, err)
+	require.Error(t, zw.WriteFileMethod(&tar.Header{Name: "tool", Typeflag: tar.TypeReg, Mode: 0755}, Method("bzip2"), strings.NewReader("")))
+
+	_, err = FileHeader(&tar.Header{Name: "alias", Typeflag: tar.TypeLink, Linkname: "tool"}, zip.Deflate)
+	require.ErrorContains(t, err, "zip archives do not support hardlink entries")
+}